@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// uploadChunkSize is how much content goes in each upload message
+const uploadChunkSize = 32 << 10
+
+// uploadAttachment streams the content of r to the server as an attachment
+// of a blog: a header, the content in chunks and its checksum last
+func uploadAttachment(ac blogpb.AttachmentServiceClient, blogID string, filename string, r io.Reader) (*blogpb.Attachment, error) {
+	stream, err := ac.UploadAttachment(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = stream.Send(&blogpb.UploadAttachmentRequest{
+		Data: &blogpb.UploadAttachmentRequest_Header_{Header: &blogpb.UploadAttachmentRequest_Header{
+			BlogId:   blogID,
+			Filename: filename,
+		}},
+	})
+
+	hash := sha256.New()
+	buf := make([]byte, uploadChunkSize)
+	for err == nil {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			err = stream.Send(&blogpb.UploadAttachmentRequest{
+				Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: buf[:n]},
+			})
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	if err == nil {
+		err = stream.Send(&blogpb.UploadAttachmentRequest{
+			Data: &blogpb.UploadAttachmentRequest_Sha256{Sha256: hex.EncodeToString(hash.Sum(nil))},
+		})
+	}
+	// a failed Send means the server gave up, and CloseAndRecv says why
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	return res.GetAttachment(), nil
+}
+
+// downloadAttachment writes the content of an attachment to w
+func downloadAttachment(ac blogpb.AttachmentServiceClient, attachmentID string, w io.Writer) (*blogpb.Attachment, error) {
+	stream, err := ac.DownloadAttachment(context.Background(), &blogpb.DownloadAttachmentRequest{
+		AttachmentId: attachmentID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var attachment *blogpb.Attachment
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return attachment, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.GetAttachment() != nil {
+			attachment = res.GetAttachment()
+		}
+		if _, err := w.Write(res.GetChunk()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// uploadFile attaches the file at path to a blog
+func uploadFile(ac blogpb.AttachmentServiceClient, blogID string, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("could not open %v: %v", path, err)
+	}
+	defer f.Close()
+
+	attachment, err := uploadAttachment(ac, blogID, filepath.Base(path), f)
+	if err != nil {
+		printError("Error happened while uploading", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Attachment has been uploaded: %v\n", attachment)
+}
+
+// downloadFile saves the content of an attachment to path
+func downloadFile(ac blogpb.AttachmentServiceClient, attachmentID string, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("could not create %v: %v", path, err)
+	}
+	attachment, err := downloadAttachment(ac, attachmentID, f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		log.Fatalf("could not write %v: %v", path, closeErr)
+	}
+	if err != nil {
+		os.Remove(path)
+		printError("Error happened while downloading", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved %v (%v, %v bytes) to %v\n", attachment.GetFilename(), attachment.GetContentType(), attachment.GetSize(), path)
+}
+
+// attachFiles uploads an image to a blog, downloads it again and tries a
+// file type the server turns down
+func attachFiles(ac blogpb.AttachmentServiceClient, blogID string) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(4 * x), G: uint8(4 * y), B: 128, A: 255})
+		}
+	}
+	var content bytes.Buffer
+	png.Encode(&content, img)
+
+	attachment, err := uploadAttachment(ac, blogID, "gradient.png", bytes.NewReader(content.Bytes()))
+	if err != nil {
+		printError("Error happened while uploading", err)
+		return
+	}
+	fmt.Printf("Attachment has been uploaded: %v\n", attachment)
+
+	var downloaded bytes.Buffer
+	if _, err := downloadAttachment(ac, attachment.GetId(), &downloaded); err != nil {
+		printError("Error happened while downloading", err)
+		return
+	}
+	fmt.Printf("Downloaded attachment matches: %v\n", bytes.Equal(content.Bytes(), downloaded.Bytes()))
+
+	page := bytes.NewReader([]byte("<html><script>alert(1)</script></html>"))
+	if _, err := uploadAttachment(ac, blogID, "page.html", page); err != nil {
+		printError("HTML attachment was rejected", err)
+	}
+}
@@ -4,17 +4,37 @@ import (
 	"context"
 	"fmt"
 	"grpc-go-course/blog/blogpb"
+	"io"
 	"log"
+	"os"
+	"strings"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func main() {
 	fmt.Println("Blog Client")
 
-	opts := grpc.WithInsecure()
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	// BLOG_TENANT names the tenant every call is for, on servers that
+	// have tenants
+	if tenant := os.Getenv("BLOG_TENANT"); tenant != "" {
+		opts = append(opts,
+			grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				return invoker(metadata.AppendToOutgoingContext(ctx, "tenant-id", tenant), method, req, reply, cc, opts...)
+			}),
+			grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return streamer(metadata.AppendToOutgoingContext(ctx, "tenant-id", tenant), desc, cc, method, opts...)
+			}),
+		)
+	}
 
-	cc, err := grpc.Dial("localhost:50051", opts)
+	cc, err := grpc.Dial("localhost:50051", opts...)
 	if err != nil {
 		log.Fatalf("could not connect: %v", err)
 	}
@@ -23,15 +43,441 @@ func main() {
 
 	c := blogpb.NewBlogServiceClient(cc)
 
+	ac := blogpb.NewAuthorServiceClient(cc)
+	atc := blogpb.NewAttachmentServiceClient(cc)
+
+	// blog_client import|export FILE moves blogs in or out as JSON Lines,
+	// blog_client upload BLOG_ID FILE and download ATTACHMENT_ID FILE move
+	// attachments and blog_client tenants ADMIN_TOKEN lists the tenants
+	if len(os.Args) > 1 {
+		usage := fmt.Sprintf("usage: %v [import|export FILE] [upload BLOG_ID FILE] [download ATTACHMENT_ID FILE] [tenants ADMIN_TOKEN]", os.Args[0])
+		switch {
+		case os.Args[1] == "import" && len(os.Args) == 3:
+			importBlogs(c, os.Args[2])
+		case os.Args[1] == "export" && len(os.Args) == 3:
+			exportBlogs(c, os.Args[2])
+		case os.Args[1] == "upload" && len(os.Args) == 4:
+			uploadFile(atc, os.Args[2], os.Args[3])
+		case os.Args[1] == "download" && len(os.Args) == 4:
+			downloadFile(atc, os.Args[2], os.Args[3])
+		case os.Args[1] == "tenants" && len(os.Args) == 3:
+			listTenants(blogpb.NewTenantServiceClient(cc), os.Args[2])
+		default:
+			log.Fatal(usage)
+		}
+		return
+	}
+
+	// Invalid fields come back one by one
+	_, err = c.CreateBlog(context.Background(), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{
+		AuthorId: "RandomId",
+		Content:  "bell\a",
+		Tags:     []string{"ok", strings.Repeat("long", 20)},
+	}})
+	if err != nil {
+		printError("Invalid blog was rejected", err)
+	}
+
+	// Blogs need a known author
+	_, err = c.CreateBlog(context.Background(), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{
+		AuthorId: "5fb3e5d0a3b3c2f1b0e0a001",
+		Title:    "Nobody wrote this",
+	}})
+	if err != nil {
+		printError("Blog by an unknown author was rejected", err)
+	}
+
+	authorRes, err := ac.CreateAuthor(context.Background(), &blogpb.CreateAuthorRequest{
+		Author: &blogpb.Author{DisplayName: "Ulaş Kasım", Bio: "Writes about Go and gRPC"},
+	})
+	if err != nil {
+		log.Fatalf("An error occured during adding author with %v", err)
+	}
+	authorID := authorRes.GetAuthor().GetId()
+	fmt.Printf("Author has been created: %v\n", authorRes)
+
+	// Watch the author's blogs change while we work on them
+	watchDone := make(chan struct{})
+	go watchBlogs(c, authorID, watchDone)
+
 	// Create Test Blog
 	blog := &blogpb.Blog{
-		Title:    "Ipek Naber",
+		Title:    "İpek Naber, güzel günler",
 		Content:  "İyidir senden naber",
-		AuthorId: "RandomId",
+		AuthorId: authorID,
+		Tags:     []string{"sohbet", "günlük"},
+		Category: "life",
 	}
 	res, err := c.CreateBlog(context.Background(), &blogpb.CreateBlogRequest{Blog: blog})
 	if err != nil {
 		log.Fatalf("An error occured during adding blog with %v", err)
 	}
-	fmt.Printf("Blog has been created: %v", res)
+	fmt.Printf("Blog has been created: %v\n", res)
+	blogID := res.GetBlog().GetId()
+
+	// Retrying with an idempotency key does not create the blog twice
+	createWithRetry(c, authorID)
+
+	// New blogs are drafts, which only show up when asked for
+	_, readErr := c.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: blogID})
+	if readErr != nil {
+		fmt.Printf("Draft is not public yet: %v\n", readErr)
+	}
+	publishRes, publishErr := c.PublishBlog(context.Background(), &blogpb.PublishBlogRequest{BlogId: blogID})
+	if publishErr != nil {
+		log.Fatalf("An error occured during publishing blog with %v", publishErr)
+	}
+	fmt.Printf("Blog has been published: %v\n", publishRes)
+
+	// Attach an image
+	attachFiles(atc, blogID)
+
+	// React to the blog and view it
+	reactAndView(c, blogID)
+
+	// Read Blog
+	fmt.Println("Reading the blog")
+	_, readErr = c.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: "5fb3e5d0a3b3c2f1b0e0a000"})
+	if readErr != nil {
+		fmt.Printf("Error happened while reading: %v\n", readErr)
+	}
+
+	readRes, readErr := c.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: blogID, IncludeAuthor: true})
+	if readErr != nil {
+		fmt.Printf("Error happened while reading: %v\n", readErr)
+	}
+	fmt.Printf("Blog was read: %v\n", readRes)
+
+	// Update Blog
+	newBlog := &blogpb.Blog{
+		Id:       blogID,
+		Title:    "Ipek Naber (edited)",
+		Content:  "# Naber\n\nİyidir senden **naber**, ben de iyiyim\n\n## Sen\n\n- sohbet\n- <script>alert(1)</script>günlük\n",
+		AuthorId: authorID,
+		Version:  readRes.GetBlog().GetVersion(),
+		Tags:     []string{"sohbet"},
+		Category: "life",
+	}
+	updateRes, updateErr := c.UpdateBlog(context.Background(), &blogpb.UpdateBlogRequest{Blog: newBlog})
+	if updateErr != nil {
+		printError("Error happened while updating", updateErr)
+	}
+	fmt.Printf("Blog was updated: %v\n", updateRes)
+
+	// The new title gave the blog a new slug, the old one still leads to it
+	for _, slug := range []string{updateRes.GetBlog().GetSlug(), res.GetBlog().GetSlug()} {
+		slugRes, slugErr := c.GetBlogBySlug(context.Background(), &blogpb.GetBlogBySlugRequest{Slug: slug})
+		if slugErr != nil {
+			fmt.Printf("Error happened while looking up %q: %v\n", slug, slugErr)
+			continue
+		}
+		if slugRes.GetMoved() {
+			fmt.Printf("Slug %q moved to %q\n", slug, slugRes.GetBlog().GetSlug())
+		} else {
+			fmt.Printf("Slug %q is blog %v\n", slug, slugRes.GetBlog().GetId())
+		}
+	}
+
+	// Render the Markdown content to HTML
+	renderRes, renderErr := c.RenderBlog(context.Background(), &blogpb.RenderBlogRequest{BlogId: blogID})
+	if renderErr != nil {
+		fmt.Printf("Error happened while rendering: %v\n", renderErr)
+	}
+	fmt.Printf("Blog was rendered (%v min read):\n%v", renderRes.GetRendered().GetReadingMinutes(), renderRes.GetRendered().GetHtml())
+	for _, e := range renderRes.GetRendered().GetToc() {
+		fmt.Printf("%*s- %v (#%v)\n", 2*(e.GetLevel()-1), "", e.GetTitle(), e.GetAnchor())
+	}
+
+	// Change only the category and read back only the title
+	maskedRes, maskedErr := c.UpdateBlog(context.Background(), &blogpb.UpdateBlogRequest{
+		Blog:       &blogpb.Blog{Id: blogID, Version: updateRes.GetBlog().GetVersion(), Category: "sohbet"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"category"}},
+	})
+	if maskedErr != nil {
+		printError("Error happened while updating the category", maskedErr)
+	}
+	fmt.Printf("Category was updated, title kept: %q in %q\n", maskedRes.GetBlog().GetTitle(), maskedRes.GetBlog().GetCategory())
+	titleRes, titleErr := c.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{
+		BlogId:   blogID,
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"id", "title"}},
+	})
+	if titleErr != nil {
+		printError("Error happened while reading the title", titleErr)
+	}
+	fmt.Printf("Blog title was read: %v\n", titleRes.GetBlog())
+	_, maskErr := c.UpdateBlog(context.Background(), &blogpb.UpdateBlogRequest{
+		Blog:       &blogpb.Blog{Id: blogID, Version: maskedRes.GetBlog().GetVersion()},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"version"}},
+	})
+	if maskErr != nil {
+		printError("Update of a field clients cannot write was rejected", maskErr)
+	}
+
+	// the same update again is now based on a stale version
+	_, updateErr = c.UpdateBlog(context.Background(), &blogpb.UpdateBlogRequest{Blog: newBlog})
+	if updateErr != nil {
+		printError("Stale update was rejected", updateErr)
+	}
+
+	// Revision history
+	diffRes, diffErr := c.DiffRevisions(context.Background(), &blogpb.DiffRevisionsRequest{
+		BlogId:      blogID,
+		FromVersion: 1,
+		ToVersion:   updateRes.GetBlog().GetVersion(),
+	})
+	if diffErr != nil {
+		fmt.Printf("Error happened while diffing: %v\n", diffErr)
+	}
+	fmt.Printf("Content changes:\n%v", diffRes.GetUnifiedDiff())
+
+	restoreRes, restoreErr := c.RestoreRevision(context.Background(), &blogpb.RestoreRevisionRequest{BlogId: blogID, Version: 1})
+	if restoreErr != nil {
+		fmt.Printf("Error happened while restoring: %v\n", restoreErr)
+	}
+	fmt.Printf("Blog was restored: %v\n", restoreRes)
+
+	// Comment on the blog
+	addComments(blogpb.NewCommentServiceClient(cc), blogID, authorID)
+
+	// Delete Blog
+	deleteRes, deleteErr := c.DeleteBlog(context.Background(), &blogpb.DeleteBlogRequest{BlogId: blogID})
+	if deleteErr != nil {
+		fmt.Printf("Error happened while deleting: %v\n", deleteErr)
+	}
+	fmt.Printf("Blog was deleted: %v\n", deleteRes)
+	<-watchDone
+
+	// Deleted blogs wait in the trash until they are purged
+	trashStream, err := c.ListTrash(context.Background(), &blogpb.ListTrashRequest{})
+	if err != nil {
+		log.Fatalf("error while calling ListTrash RPC: %v", err)
+	}
+	for {
+		res, err := trashStream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error while reading stream: %v", err)
+		}
+		fmt.Printf("In the trash: %v\n", res.GetBlog())
+	}
+
+	// List Blogs, two at a time, resuming from the last cursor we received
+	cursor := ""
+	for page := 1; ; page++ {
+		fmt.Printf("Listing page %v\n", page)
+		last, count := listBlogs(c, 2, cursor)
+		if count == 0 {
+			break
+		}
+		cursor = last
+	}
+
+	// Tags in use
+	tagsRes, err := c.ListTags(context.Background(), &blogpb.ListTagsRequest{})
+	if err != nil {
+		fmt.Printf("Error happened while listing tags: %v\n", err)
+	}
+	for _, t := range tagsRes.GetTags() {
+		fmt.Printf("#%v (%v)\n", t.GetTag(), t.GetCount())
+	}
+
+	// Search Blogs
+	searchBlogs(c, "naber")
+}
+
+// watchBlogs prints the changes to blogs by authorID until it sees one of
+// them deleted
+func watchBlogs(c blogpb.BlogServiceClient, authorID string, done chan<- struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := c.WatchBlogs(ctx, &blogpb.WatchBlogsRequest{AuthorId: authorID})
+	if err != nil {
+		fmt.Printf("Error happened while watching: %v\n", err)
+		return
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			// reconnecting with AfterSequence set to the last sequence
+			// received, 0 if none was, and HasAfterSequence set would
+			// resume without missing a change
+			fmt.Printf("Watch broke: %v\n", err)
+			return
+		}
+		fmt.Printf("Change #%v: %v %v (version %v)\n", res.GetSequence(), res.GetKind(), res.GetBlog().GetId(), res.GetBlog().GetVersion())
+		if res.GetKind() == blogpb.WatchBlogsResponse_DELETED {
+			return
+		}
+	}
+}
+
+func searchBlogs(c blogpb.BlogServiceClient, query string) {
+	fmt.Printf("Searching blogs for %q\n", query)
+	res, err := c.SearchBlogs(context.Background(), &blogpb.SearchBlogsRequest{Query: query})
+	if err != nil {
+		fmt.Printf("Error happened while searching: %v\n", err)
+		return
+	}
+	for _, r := range res.GetResults() {
+		fmt.Printf("%.3f %v (%v)\n", r.GetScore(), r.GetTitleHighlight(), r.GetBlog().GetId())
+		for _, snippet := range r.GetSnippets() {
+			fmt.Printf("\t%v\n", snippet)
+		}
+	}
+}
+
+// listBlogs streams one page of blogs and returns the cursor of the last
+// blog received, which is where a following call (or a retry after the
+// stream broke) should resume
+func listBlogs(c blogpb.BlogServiceClient, pageSize int32, cursor string) (string, int) {
+	stream, err := c.ListBlogs(context.Background(), &blogpb.ListBlogsRequest{
+		PageSize:  pageSize,
+		SortOrder: blogpb.ListBlogsRequest_NEWEST_FIRST,
+		Cursor:    cursor,
+	})
+	if err != nil {
+		log.Fatalf("error while calling ListBlogs RPC: %v", err)
+	}
+	count := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Stream broke, resume with cursor %q: %v\n", cursor, err)
+			break
+		}
+		fmt.Println(res.GetBlog())
+		cursor = res.GetCursor()
+		count++
+	}
+	return cursor, count
+}
+
+func addComments(cs blogpb.CommentServiceClient, blogID string, authorID string) {
+	fmt.Println("Adding comments")
+	top, err := cs.AddComment(context.Background(), &blogpb.AddCommentRequest{
+		Comment: &blogpb.Comment{BlogId: blogID, AuthorId: authorID, Content: "Güzel yazı"},
+	})
+	if err != nil {
+		fmt.Printf("Error happened while commenting: %v\n", err)
+		return
+	}
+	_, err = cs.AddComment(context.Background(), &blogpb.AddCommentRequest{
+		Comment: &blogpb.Comment{BlogId: blogID, ParentId: top.GetComment().GetId(), AuthorId: authorID, Content: "Teşekkürler"},
+	})
+	if err != nil {
+		fmt.Printf("Error happened while replying: %v\n", err)
+	}
+
+	stream, err := cs.ListComments(context.Background(), &blogpb.ListCommentsRequest{BlogId: blogID})
+	if err != nil {
+		log.Fatalf("error while calling ListComments RPC: %v", err)
+	}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error while reading stream: %v", err)
+		}
+		fmt.Printf("%*s%v\n", 2*res.GetDepth(), "", res.GetComment().GetContent())
+	}
+}
+
+func reactAndView(c blogpb.BlogServiceClient, blogID string) {
+	for _, r := range []struct {
+		user     string
+		reaction blogpb.Reaction
+	}{
+		{"ipek", blogpb.Reaction_LIKE},
+		{"ulas", blogpb.Reaction_LIKE},
+		// a user has one reaction, reacting again replaces it
+		{"ipek", blogpb.Reaction_LOVE},
+	} {
+		res, err := c.ReactToBlog(context.Background(), &blogpb.ReactToBlogRequest{BlogId: blogID, UserId: r.user, Reaction: r.reaction})
+		if err != nil {
+			printError("Error happened while reacting", err)
+			return
+		}
+		fmt.Printf("%v reacted with %v: %v\n", r.user, r.reaction, res.GetBlog().GetReactions())
+	}
+	removeRes, err := c.RemoveReaction(context.Background(), &blogpb.RemoveReactionRequest{BlogId: blogID, UserId: "ulas"})
+	if err != nil {
+		printError("Error happened while removing the reaction", err)
+	}
+	fmt.Printf("ulas took the reaction back: %v\n", removeRes.GetBlog().GetReactions())
+
+	// the second view comes too soon after the first to count
+	for i := 0; i < 2; i++ {
+		viewRes, err := c.RecordView(context.Background(), &blogpb.RecordViewRequest{BlogId: blogID, ViewerId: "ipek"})
+		if err != nil {
+			printError("Error happened while recording a view", err)
+			return
+		}
+		fmt.Printf("View counted: %v, %v views\n", viewRes.GetCounted(), viewRes.GetViewCount())
+	}
+}
+
+func createWithRetry(c blogpb.BlogServiceClient, authorID string) {
+	req := &blogpb.CreateBlogRequest{
+		Blog:           &blogpb.Blog{Title: "Sent twice", Content: "The first response got lost", AuthorId: authorID},
+		IdempotencyKey: fmt.Sprintf("client-%v", time.Now().UnixNano()),
+	}
+	first, err := c.CreateBlog(context.Background(), req)
+	if err != nil {
+		printError("Error happened while creating", err)
+		return
+	}
+	// the key can go in the metadata as well
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "idempotency-key", req.GetIdempotencyKey())
+	retry, err := c.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: req.GetBlog()})
+	if err != nil {
+		printError("Error happened while retrying", err)
+		return
+	}
+	fmt.Printf("Retry returned the same blog: %v\n", first.GetBlog().GetId() == retry.GetBlog().GetId())
+
+	req.Blog.Title = "Something else"
+	if _, err := c.CreateBlog(context.Background(), req); err != nil {
+		printError("Reused idempotency key was rejected", err)
+	}
+}
+
+func listTenants(tc blogpb.TenantServiceClient, adminToken string) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "admin-token", adminToken)
+	res, err := tc.ListTenants(ctx, &blogpb.ListTenantsRequest{})
+	if err != nil {
+		printError("Error happened while listing tenants", err)
+		os.Exit(1)
+	}
+	for _, t := range res.GetTenants() {
+		fmt.Printf("Tenant: %v\n", t)
+	}
+}
+
+// printError prints err, and for a request the server found invalid the
+// fields it rejected along with the reason for each
+func printError(what string, err error) {
+	st := status.Convert(err)
+	var fields []*errdetails.BadRequest_FieldViolation
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			fields = append(fields, br.GetFieldViolations()...)
+		}
+	}
+	if len(fields) == 0 {
+		fmt.Printf("%v: %v\n", what, err)
+		return
+	}
+	fmt.Printf("%v: %v\n", what, st.Code())
+	for _, f := range fields {
+		fmt.Printf("  %v: %v\n", f.GetField(), f.GetDescription())
+	}
 }
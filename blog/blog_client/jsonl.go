@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// maxLineSize bounds a single blog in a JSON Lines file
+const maxLineSize = 16 << 20
+
+// exportBlogs writes every blog to path as JSON Lines, one Blog per line
+func exportBlogs(c blogpb.BlogServiceClient, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("could not create %v: %v", path, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	stream, err := c.ExportBlogs(context.Background(), &blogpb.ExportBlogsRequest{})
+	if err != nil {
+		log.Fatalf("error while calling ExportBlogs RPC: %v", err)
+	}
+	count := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error while reading stream: %v", err)
+		}
+		line, err := protojson.Marshal(res.GetBlog())
+		if err != nil {
+			log.Fatalf("could not encode blog %v: %v", res.GetBlog().GetId(), err)
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		count++
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatalf("could not write %v: %v", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		log.Fatalf("could not write %v: %v", path, err)
+	}
+	fmt.Printf("Exported %v blogs to %v\n", count, path)
+}
+
+// importBlogs streams the blogs in the JSON Lines file at path to the
+// server. Lines that are not a valid Blog are reported and skipped.
+func importBlogs(c blogpb.BlogServiceClient, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("could not open %v: %v", path, err)
+	}
+	defer f.Close()
+
+	stream, err := c.ImportBlogs(context.Background())
+	if err != nil {
+		log.Fatalf("error while calling ImportBlogs RPC: %v", err)
+	}
+
+	// the line each sent blog came from, to report results against
+	var lines []int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		blog := &blogpb.Blog{}
+		if err := protojson.Unmarshal([]byte(text), blog); err != nil {
+			fmt.Printf("%v:%v: skipped, not a blog: %v\n", path, n, err)
+			continue
+		}
+		if err := stream.Send(&blogpb.ImportBlogsRequest{Blog: blog}); err != nil {
+			// the real error comes with CloseAndRecv
+			break
+		}
+		lines = append(lines, n)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("could not read %v: %v", path, err)
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("error while importing: %v", err)
+	}
+	for _, r := range res.GetResults() {
+		if r.GetError() != "" && int(r.GetIndex()) < len(lines) {
+			fmt.Printf("%v:%v: %v\n", path, lines[r.GetIndex()], r.GetError())
+		}
+	}
+	fmt.Printf("Imported %v blogs, %v failed\n", res.GetImported(), res.GetFailed())
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxFilenameLength = 255
+	// sniffLength is how much of the content http.DetectContentType looks at
+	sniffLength       = 512
+	downloadChunkSize = 64 << 10
+	// blobGrace is how old a blob without an attachment has to be before
+	// the purger removes it, which leaves uploads in flight alone
+	blobGrace = time.Hour
+)
+
+// attachmentTypes are the content types an attachment may have, as sniffed
+// from its content. HTML and anything else a browser might run is left out.
+var attachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+	"audio/mpeg":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+type attachmentServer struct {
+	blogpb.UnimplementedAttachmentServiceServer
+
+	// store routes to the tenant of each call, and so do the blobs
+	store BlogStore
+	// maxSize is the largest attachment accepted, in bytes
+	maxSize int64
+	// adminToken lets a call download attachments of blogs that are not
+	// published
+	adminToken string
+}
+
+type attachmentItem struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	BlogID      primitive.ObjectID `bson:"blog_id"`
+	Filename    string             `bson:"filename"`
+	ContentType string             `bson:"content_type"`
+	Size        int64              `bson:"size"`
+	SHA256      string             `bson:"sha256"`
+	CreatedAt   time.Time          `bson:"created_at"`
+}
+
+func (s *attachmentServer) UploadAttachment(stream blogpb.AttachmentService_UploadAttachmentServer) error {
+	fmt.Println("Upload attachment request")
+
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return fieldError("header", "is required")
+	}
+	if err != nil {
+		return err
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return fieldError("header", "must come before the content")
+	}
+	if err := s.validateHeader(header); err != nil {
+		return err
+	}
+	blogID, _ := primitive.ObjectIDFromHex(header.GetBlogId())
+	if _, err := s.store.Get(ctx, blogID); err != nil {
+		return storeError(err, header.GetBlogId())
+	}
+	t, err := tenantFrom(ctx)
+	if err != nil {
+		return storeError(err, header.GetBlogId())
+	}
+
+	w, err := t.blobs.create()
+	if err != nil {
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Cannot store the attachment: %v", err),
+		)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			w.abort()
+		}
+	}()
+
+	hash := sha256.New()
+	var head []byte // the start of the content, to sniff its type from
+	size := int64(0)
+	sum := ""
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if sum != "" {
+			return fieldError("sha256", "must come after all of the content")
+		}
+
+		switch data := req.GetData().(type) {
+		case *blogpb.UploadAttachmentRequest_Chunk:
+			size += int64(len(data.Chunk))
+			if size > s.maxSize {
+				return fieldError("chunk", "the attachment is larger than the limit of %v bytes", s.maxSize)
+			}
+			if header.GetSize() > 0 && size > header.GetSize() {
+				return fieldError("chunk", "the attachment is larger than the %v bytes of header.size", header.GetSize())
+			}
+			if len(head) < sniffLength {
+				n := sniffLength - len(head)
+				if n > len(data.Chunk) {
+					n = len(data.Chunk)
+				}
+				head = append(head, data.Chunk[:n]...)
+			}
+			hash.Write(data.Chunk)
+			if _, err := w.Write(data.Chunk); err != nil {
+				return status.Errorf(
+					codes.Internal,
+					fmt.Sprintf("Cannot store the attachment: %v", err),
+				)
+			}
+		case *blogpb.UploadAttachmentRequest_Sha256:
+			if data.Sha256 == "" {
+				return fieldError("sha256", "is required")
+			}
+			sum = data.Sha256
+		default:
+			return fieldError("header", "must only be sent once")
+		}
+	}
+
+	if size == 0 {
+		return fieldError("chunk", "the attachment is empty")
+	}
+	if header.GetSize() > 0 && size != header.GetSize() {
+		return fieldError("header.size", "is %v bytes, but %v were sent", header.GetSize(), size)
+	}
+	if sum == "" {
+		return fieldError("sha256", "is required after the content")
+	}
+	got := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(got, sum) {
+		return status.Errorf(
+			codes.DataLoss,
+			fmt.Sprintf("The content received has SHA-256 %v, not %v", got, sum),
+		)
+	}
+	contentType := http.DetectContentType(head)
+	if !attachmentTypes[strings.TrimSpace(strings.Split(contentType, ";")[0])] {
+		return fieldError("chunk", "the attachment is %v, which is not allowed", contentType)
+	}
+
+	id := primitive.NewObjectID()
+	if err := w.commit(id); err != nil {
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Cannot store the attachment: %v", err),
+		)
+	}
+	committed = true
+
+	created, err := s.store.CreateAttachment(ctx, &attachmentItem{
+		ID:          id,
+		BlogID:      blogID,
+		Filename:    header.GetFilename(),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      got,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		t.blobs.remove(id)
+		return storeError(err, header.GetBlogId())
+	}
+
+	fmt.Printf("Stored attachment %v of %v bytes on blog %v\n", id.Hex(), size, header.GetBlogId())
+	return stream.SendAndClose(&blogpb.UploadAttachmentResponse{
+		Attachment: dataToAttachmentPb(created),
+	})
+}
+
+func (s *attachmentServer) validateHeader(header *blogpb.UploadAttachmentRequest_Header) error {
+	v := violations{}
+	v.id("header.blog_id", header.GetBlogId())
+	v.line("header.filename", header.GetFilename(), true, maxFilenameLength)
+	if strings.ContainsAny(header.GetFilename(), `/\`) {
+		v.add("header.filename", "must not contain a path")
+	}
+	v.nonNegative("header.size", header.GetSize())
+	if header.GetSize() > s.maxSize {
+		v.add("header.size", "must be at most %v bytes, got %v", s.maxSize, header.GetSize())
+	}
+	return v.err()
+}
+
+func (s *attachmentServer) DownloadAttachment(req *blogpb.DownloadAttachmentRequest, stream blogpb.AttachmentService_DownloadAttachmentServer) error {
+	fmt.Println("Download attachment request")
+
+	v := violations{}
+	v.id("attachment_id", req.GetAttachmentId())
+	if err := v.err(); err != nil {
+		return err
+	}
+	oid, _ := primitive.ObjectIDFromHex(req.GetAttachmentId())
+
+	ctx := stream.Context()
+	if err := checkUnpublished(ctx, s.adminToken, req.GetIncludeUnpublished()); err != nil {
+		return err
+	}
+	notFound := status.Errorf(
+		codes.NotFound,
+		fmt.Sprintf("Cannot find attachment with specified ID: %v", req.GetAttachmentId()),
+	)
+	data, err := s.store.GetAttachment(ctx, oid)
+	if err == errAttachmentNotFound {
+		return notFound
+	}
+	if err != nil {
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+	// attachments go with their blog
+	blog, err := s.store.Get(ctx, data.BlogID)
+	if err == nil && !blog.published() && !req.GetIncludeUnpublished() {
+		err = errNotFound
+	}
+	if err == errNotFound {
+		return notFound
+	}
+	if err != nil {
+		return storeError(err, data.BlogID.Hex())
+	}
+
+	t, err := tenantFrom(ctx)
+	if err != nil {
+		return storeError(err, data.BlogID.Hex())
+	}
+	f, err := t.blobs.open(oid)
+	if err != nil {
+		code := codes.Internal
+		if os.IsNotExist(err) {
+			code = codes.DataLoss
+		}
+		return status.Errorf(
+			code,
+			fmt.Sprintf("Cannot read the content of attachment %v: %v", req.GetAttachmentId(), err),
+		)
+	}
+	defer f.Close()
+
+	err = stream.Send(&blogpb.DownloadAttachmentResponse{
+		Data: &blogpb.DownloadAttachmentResponse_Attachment{Attachment: dataToAttachmentPb(data)},
+	})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			sendErr := stream.Send(&blogpb.DownloadAttachmentResponse{
+				Data: &blogpb.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]},
+			})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(
+				codes.Internal,
+				fmt.Sprintf("Cannot read the content of attachment %v: %v", req.GetAttachmentId(), err),
+			)
+		}
+	}
+}
+
+func dataToAttachmentPb(data *attachmentItem) *blogpb.Attachment {
+	return &blogpb.Attachment{
+		Id:          data.ID.Hex(),
+		BlogId:      data.BlogID.Hex(),
+		Filename:    data.Filename,
+		ContentType: data.ContentType,
+		Size:        data.Size,
+		Sha256:      data.SHA256,
+		CreatedAt:   timestamppb.New(data.CreatedAt),
+	}
+}
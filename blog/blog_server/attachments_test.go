@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"grpc-go-course/blog/blogpb"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploadStream plays back reqs to UploadAttachment
+type uploadStream struct {
+	blogpb.AttachmentService_UploadAttachmentServer
+	ctx  context.Context
+	reqs []*blogpb.UploadAttachmentRequest
+	res  *blogpb.UploadAttachmentResponse
+}
+
+func (s *uploadStream) Context() context.Context { return s.ctx }
+
+func (s *uploadStream) Recv() (*blogpb.UploadAttachmentRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *uploadStream) SendAndClose(res *blogpb.UploadAttachmentResponse) error {
+	s.res = res
+	return nil
+}
+
+func TestUploadAttachmentSize(t *testing.T) {
+	content := []byte("plain text notes")
+	tests := []struct {
+		name string
+		size int64 // in the header
+		code codes.Code
+	}{
+		{"no size", 0, codes.OK},
+		{"right size", int64(len(content)), codes.OK},
+		{"cut short", int64(len(content)) + 1, codes.InvalidArgument},
+		{"longer", int64(len(content)) - 1, codes.InvalidArgument},
+		{"over the limit", 1 << 20, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			blog, err := (tenantStore{}).Create(ctx, &blogItem{Title: "t", Status: statusPublished})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &attachmentServer{store: tenantStore{}, maxSize: 1 << 10}
+
+			sum := sha256.Sum256(content)
+			stream := &uploadStream{ctx: ctx, reqs: []*blogpb.UploadAttachmentRequest{
+				{Data: &blogpb.UploadAttachmentRequest_Header_{Header: &blogpb.UploadAttachmentRequest_Header{
+					BlogId: blog.ID.Hex(), Filename: "notes.txt", Size: tt.size,
+				}}},
+				{Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: content[:5]}},
+				{Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: content[5:]}},
+				{Data: &blogpb.UploadAttachmentRequest_Sha256{Sha256: hex.EncodeToString(sum[:])}},
+			}}
+			err = s.UploadAttachment(stream)
+			if status.Code(err) != tt.code {
+				t.Fatalf("UploadAttachment failed with %v, want %v", err, tt.code)
+			}
+			if err == nil && stream.res.GetAttachment().GetSize() != int64(len(content)) {
+				t.Errorf("stored %v bytes, want %v", stream.res.GetAttachment().GetSize(), len(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type authorServer struct {
+	blogpb.UnimplementedAuthorServiceServer
+
+	store AuthorStore
+}
+
+type authorItem struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	DisplayName string             `bson:"display_name"`
+	Email       string             `bson:"email,omitempty"`
+	Bio         string             `bson:"bio,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at"`
+}
+
+func (s *authorServer) CreateAuthor(ctx context.Context, req *blogpb.CreateAuthorRequest) (*blogpb.CreateAuthorResponse, error) {
+	fmt.Println("Create author request")
+
+	author := req.GetAuthor()
+	if strings.TrimSpace(author.GetDisplayName()) == "" {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Received an empty display name"),
+		)
+	}
+
+	created, err := s.store.CreateAuthor(ctx, &authorItem{
+		DisplayName: author.GetDisplayName(),
+		Email:       author.GetEmail(),
+		Bio:         author.GetBio(),
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+
+	return &blogpb.CreateAuthorResponse{
+		Author: dataToAuthorPb(created),
+	}, nil
+}
+
+func (s *authorServer) GetAuthor(ctx context.Context, req *blogpb.GetAuthorRequest) (*blogpb.GetAuthorResponse, error) {
+	fmt.Println("Get author request")
+
+	oid, err := parseAuthorID(req.GetAuthorId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.GetAuthor(ctx, oid)
+	if err != nil {
+		return nil, authorStoreError(err, req.GetAuthorId())
+	}
+
+	return &blogpb.GetAuthorResponse{
+		Author: dataToAuthorPb(data),
+	}, nil
+}
+
+func (s *authorServer) UpdateAuthor(ctx context.Context, req *blogpb.UpdateAuthorRequest) (*blogpb.UpdateAuthorResponse, error) {
+	fmt.Println("Update author request")
+
+	author := req.GetAuthor()
+	oid, err := parseAuthorID(author.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(author.GetDisplayName()) == "" {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Received an empty display name"),
+		)
+	}
+
+	data, err := s.store.UpdateAuthor(ctx, &authorItem{
+		ID:          oid,
+		DisplayName: author.GetDisplayName(),
+		Email:       author.GetEmail(),
+		Bio:         author.GetBio(),
+	})
+	if err != nil {
+		return nil, authorStoreError(err, author.GetId())
+	}
+
+	return &blogpb.UpdateAuthorResponse{
+		Author: dataToAuthorPb(data),
+	}, nil
+}
+
+func (s *authorServer) ListAuthors(req *blogpb.ListAuthorsRequest, stream blogpb.AuthorService_ListAuthorsServer) error {
+	fmt.Println("List authors request")
+
+	sendErr := error(nil)
+	err := s.store.ListAuthors(stream.Context(), func(data *authorItem) error {
+		sendErr = stream.Send(&blogpb.ListAuthorsResponse{
+			Author: dataToAuthorPb(data),
+		})
+		return sendErr
+	})
+	if err != nil {
+		if err == sendErr {
+			return err
+		}
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error: %v", err),
+		)
+	}
+	return nil
+}
+
+// checkAuthor makes sure a blog is written by a known author. An unknown
+// or malformed author id fails with FailedPrecondition, since the author
+// has to be created first.
+func checkAuthor(ctx context.Context, store AuthorStore, authorID string) (*authorItem, error) {
+	unknown := status.Errorf(
+		codes.FailedPrecondition,
+		fmt.Sprintf("Unknown author: %q, create the author first", authorID),
+	)
+
+	oid, err := primitive.ObjectIDFromHex(authorID)
+	if err != nil {
+		return nil, unknown
+	}
+	author, err := store.GetAuthor(ctx, oid)
+	if err == errAuthorNotFound {
+		return nil, unknown
+	}
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+	return author, nil
+}
+
+// authorNames looks up display names for blogs, remembering the ones it
+// has seen so a listing asks the store once per author
+type authorNames struct {
+	store AuthorStore
+	names map[string]string
+}
+
+func newAuthorNames(store AuthorStore) *authorNames {
+	return &authorNames{store: store, names: make(map[string]string)}
+}
+
+// fill sets blog.AuthorName, leaving it empty if the author is unknown
+func (a *authorNames) fill(ctx context.Context, blog *blogpb.Blog) {
+	id := blog.GetAuthorId()
+	name, ok := a.names[id]
+	if !ok {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			if author, err := a.store.GetAuthor(ctx, oid); err == nil {
+				name = author.DisplayName
+			}
+		}
+		a.names[id] = name
+	}
+	blog.AuthorName = name
+}
+
+func parseAuthorID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Cannot parse author ID: %v", id),
+		)
+	}
+	return oid, nil
+}
+
+func authorStoreError(err error, id string) error {
+	if err == errAuthorNotFound {
+		return status.Errorf(
+			codes.NotFound,
+			fmt.Sprintf("Cannot find author with specified ID: %v", id),
+		)
+	}
+	return status.Errorf(
+		codes.Internal,
+		fmt.Sprintf("Internal error: %v", err),
+	)
+}
+
+func dataToAuthorPb(data *authorItem) *blogpb.Author {
+	return &blogpb.Author{
+		Id:          data.ID.Hex(),
+		DisplayName: data.DisplayName,
+		Email:       data.Email,
+		Bio:         data.Bio,
+		CreatedAt:   timestamppb.New(data.CreatedAt),
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// blobStore keeps the content of attachments as files under dir, named by
+// attachment id and spread over subdirectories by the last two hex digits
+// of the id, so no directory grows too large. Content is written to a
+// temporary file and renamed into place once it is complete, so a blob is
+// either whole or missing.
+type blobStore struct {
+	dir string
+}
+
+func newBlobStore(dir string) (*blobStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, err
+	}
+	return &blobStore{dir: dir}, nil
+}
+
+func (b *blobStore) path(id primitive.ObjectID) string {
+	hex := id.Hex()
+	return filepath.Join(b.dir, hex[len(hex)-2:], hex)
+}
+
+// create starts a new blob. Write the content to it, then commit or abort.
+func (b *blobStore) create() (*blobWriter, error) {
+	f, err := ioutil.TempFile(filepath.Join(b.dir, "tmp"), "upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{store: b, f: f}, nil
+}
+
+func (b *blobStore) open(id primitive.ObjectID) (*os.File, error) {
+	return os.Open(b.path(id))
+}
+
+func (b *blobStore) remove(id primitive.ObjectID) error {
+	return os.Remove(b.path(id))
+}
+
+// sweep removes the blobs older than grace that no attachment refers to,
+// which are left behind when a blog is purged or storing an attachment
+// fails halfway, along with uploads that were abandoned for that long.
+// It returns how many files it removed.
+func (b *blobStore) sweep(ctx context.Context, store AttachmentStore, grace time.Duration) (int, error) {
+	cutoff := time.Now().Add(-grace)
+	n := 0
+	err := filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if filepath.Base(filepath.Dir(path)) != "tmp" {
+			id, err := primitive.ObjectIDFromHex(info.Name())
+			if err != nil {
+				// not ours
+				return nil
+			}
+			if _, err := store.GetAttachment(ctx, id); err != errAttachmentNotFound {
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// blobWriter writes the content of a new blob
+type blobWriter struct {
+	store *blobStore
+	f     *os.File
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+// commit makes the content durable and readable as the blob of id
+func (w *blobWriter) commit(id primitive.ObjectID) error {
+	err := w.f.Sync()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	path := w.store.path(id)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		err = os.Rename(w.f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// abort throws away what was written. It must not be called after commit.
+func (w *blobWriter) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
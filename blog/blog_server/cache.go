@@ -0,0 +1,294 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"grpc-go-course/blog/blogpb"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/sync/singleflight"
+)
+
+// errLoadCanceled is what callers sharing a cache miss get when the
+// caller that read the blog for all of them gave up
+var errLoadCanceled = errors.New("the read shared with another request was canceled")
+
+// maxTrackedWrites bounds the blogs a blogCache remembers the last write
+// to; past it, the cache forgets them and turns away every read in flight
+const maxTrackedWrites = 10000
+
+// blogCache keeps the blogs read most recently, up to a number of them and
+// each for at most a TTL. Items in it are shared by every reader, which is
+// safe since blog items are never changed in place.
+type blogCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[primitive.ObjectID]*list.Element
+	// slugs finds the entries by the slugs their blogs have or had
+	slugs map[string]primitive.ObjectID
+	// seq counts invalidations and written holds the last one of each
+	// blog, so a read that started before a write to its blog does not
+	// fill the cache, while reads of other blogs are not disturbed. Reads
+	// that started before floor are all turned away.
+	seq     uint64
+	written map[primitive.ObjectID]uint64
+	floor   uint64
+
+	hits, misses, waited, evictions, expirations, invalidations int64
+}
+
+type cacheEntry struct {
+	item    *blogItem
+	expires time.Time
+}
+
+func newBlogCache(size int, ttl time.Duration) *blogCache {
+	return &blogCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[primitive.ObjectID]*list.Element),
+		slugs:   make(map[string]primitive.ObjectID),
+		written: make(map[primitive.ObjectID]uint64),
+	}
+}
+
+// get returns the blog with id if it is cached and fresh, and otherwise
+// the point to read it from
+func (c *blogCache) get(id primitive.ObjectID) (*blogItem, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[id]
+	if ok && time.Now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		c.expirations++
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, c.seq, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).item, c.seq, true
+}
+
+// getBySlug is get for the blog that has or had slug
+func (c *blogCache) getBySlug(slug string) (*blogItem, uint64, bool) {
+	c.mu.Lock()
+	id, ok := c.slugs[slug]
+	c.mu.Unlock()
+	if !ok {
+		id = primitive.NilObjectID
+	}
+	return c.get(id)
+}
+
+// put caches item read from the point get returned, and reports whether
+// it did, which it does not if the blog was written since
+func (c *blogCache) put(item *blogItem, from uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if from < c.floor || c.written[item.ID] > from {
+		return false
+	}
+	if el, ok := c.entries[item.ID]; ok {
+		c.remove(el)
+	}
+	c.entries[item.ID] = c.lru.PushFront(&cacheEntry{item: item, expires: time.Now().Add(c.ttl)})
+	for _, slug := range item.Slugs {
+		c.slugs[slug] = item.ID
+	}
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+	return true
+}
+
+// invalidate drops the blog with id after a write to it
+func (c *blogCache) invalidate(id primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	if _, ok := c.written[id]; !ok && len(c.written) >= maxTrackedWrites {
+		c.written = make(map[primitive.ObjectID]uint64)
+		c.floor = c.seq
+	}
+	c.written[id] = c.seq
+	c.invalidations++
+	if el, ok := c.entries[id]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *blogCache) remove(el *list.Element) {
+	item := c.lru.Remove(el).(*cacheEntry).item
+	delete(c.entries, item.ID)
+	for _, slug := range item.Slugs {
+		if c.slugs[slug] == item.ID {
+			delete(c.slugs, slug)
+		}
+	}
+}
+
+// collapsed records a miss answered by a read another request made
+func (c *blogCache) collapsed() {
+	c.mu.Lock()
+	c.waited++
+	c.mu.Unlock()
+}
+
+// stats returns the counts of the cache, with a nil cache being one that
+// is turned off
+func (c *blogCache) stats() *blogpb.CacheStats {
+	if c == nil {
+		return &blogpb.CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &blogpb.CacheStats{
+		Enabled:       true,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Collapsed:     c.waited,
+		Evictions:     c.evictions,
+		Expirations:   c.expirations,
+		Invalidations: c.invalidations,
+		Size:          int64(c.lru.Len()),
+		Capacity:      int64(c.size),
+	}
+}
+
+// cachedStore is a BlogStore that reads blogs through a blogCache, and
+// drops them from it on every write. Concurrent misses on a blog are
+// read from the store once. With several servers on one Mongo database
+// the writes of the others show after the TTL at the latest.
+type cachedStore struct {
+	BlogStore
+	cache *blogCache
+	group singleflight.Group
+}
+
+func newCachedStore(store BlogStore, cache *blogCache) *cachedStore {
+	return &cachedStore{BlogStore: store, cache: cache}
+}
+
+func (s *cachedStore) Get(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	item, from, ok := s.cache.get(id)
+	if ok {
+		return item, nil
+	}
+	return s.load(ctx, "id "+id.Hex(), from, func(ctx context.Context) (*blogItem, error) {
+		return s.BlogStore.Get(ctx, id)
+	})
+}
+
+func (s *cachedStore) GetBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	item, from, ok := s.cache.getBySlug(slug)
+	if ok {
+		return item, nil
+	}
+	return s.load(ctx, "slug "+slug, from, func(ctx context.Context) (*blogItem, error) {
+		return s.BlogStore.GetBySlug(ctx, slug)
+	})
+}
+
+// load reads a blog the cache missed, once for every caller missing it
+// under key at the same time, and caches it
+func (s *cachedStore) load(ctx context.Context, key string, from uint64, read func(context.Context) (*blogItem, error)) (*blogItem, error) {
+	leader := false
+	v, err, _ := s.group.Do(key, func() (interface{}, error) {
+		leader = true
+		item, err := read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, errLoadCanceled
+			}
+			return nil, err
+		}
+		return loaded{item: item, fresh: s.cache.put(item, from)}, nil
+	})
+	if !leader {
+		s.cache.collapsed()
+	}
+	if err == errLoadCanceled {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// only the caller that read for everyone gave up, so read again
+		return read(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := v.(loaded)
+	if !l.fresh && !leader {
+		// the blog was written while it was read, maybe before this
+		// caller came, so it must not see the read from before the write
+		return read(ctx)
+	}
+	return l.item, nil
+}
+
+// loaded is a blog read for the callers of load, fresh unless it was
+// written while it was read
+type loaded struct {
+	item  *blogItem
+	fresh bool
+}
+
+// the writes below drop the blog whether or not they succeed, since a
+// version conflict means the cached blog is stale
+
+func (s *cachedStore) Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error) {
+	defer s.cache.invalidate(item.ID)
+	return s.BlogStore.Update(ctx, item, fields)
+}
+
+func (s *cachedStore) Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	defer s.cache.invalidate(id)
+	return s.BlogStore.Delete(ctx, id)
+}
+
+func (s *cachedStore) Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	defer s.cache.invalidate(id)
+	return s.BlogStore.Restore(ctx, id)
+}
+
+func (s *cachedStore) SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error) {
+	defer s.cache.invalidate(id)
+	return s.BlogStore.SetStatus(ctx, id, from, to, publishAt)
+}
+
+func (s *cachedStore) PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error) {
+	published, err := s.BlogStore.PublishDue(ctx, now)
+	for _, item := range published {
+		s.cache.invalidate(item.ID)
+	}
+	return published, err
+}
+
+func (s *cachedStore) React(ctx context.Context, blogID primitive.ObjectID, user, reaction string) (*blogItem, error) {
+	defer s.cache.invalidate(blogID)
+	return s.BlogStore.React(ctx, blogID, user, reaction)
+}
+
+func (s *cachedStore) Unreact(ctx context.Context, blogID primitive.ObjectID, user string) (*blogItem, error) {
+	defer s.cache.invalidate(blogID)
+	return s.BlogStore.Unreact(ctx, blogID, user)
+}
+
+func (s *cachedStore) RecordView(ctx context.Context, blogID primitive.ObjectID, viewer string, window time.Duration) (*blogItem, bool, error) {
+	item, counted, err := s.BlogStore.RecordView(ctx, blogID, viewer, window)
+	// a repeat view changes nothing, and popular blogs get plenty of them
+	if counted || err != nil {
+		s.cache.invalidate(blogID)
+	}
+	return item, counted, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBlogCachePut(t *testing.T) {
+	a := &blogItem{ID: primitive.NewObjectID(), Slugs: []string{"a"}}
+	b := primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		before func(c *blogCache) // before the read of a starts
+		during func(c *blogCache) // while it is read
+		cached bool
+	}{
+		{"unwritten", nil, nil, true},
+		{"written before", func(c *blogCache) { c.invalidate(a.ID) }, nil, true},
+		{"written during", nil, func(c *blogCache) { c.invalidate(a.ID) }, false},
+		{"other written during", nil, func(c *blogCache) { c.invalidate(b) }, true},
+		{"too many written during", nil, func(c *blogCache) {
+			for i := 0; i < maxTrackedWrites+1; i++ {
+				c.invalidate(primitive.NewObjectID())
+			}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBlogCache(10, time.Minute)
+			if tt.before != nil {
+				tt.before(c)
+			}
+			_, from, ok := c.get(a.ID)
+			if ok {
+				t.Fatal("get found a blog in an empty cache")
+			}
+			if tt.during != nil {
+				tt.during(c)
+			}
+			if got := c.put(a, from); got != tt.cached {
+				t.Errorf("put = %v, want %v", got, tt.cached)
+			}
+			if _, _, ok := c.getBySlug("a"); ok != tt.cached {
+				t.Errorf("getBySlug found the blog: %v, want %v", ok, tt.cached)
+			}
+		})
+	}
+}
+
+func TestBlogCacheLimits(t *testing.T) {
+	c := newBlogCache(2, 50*time.Millisecond)
+	items := []*blogItem{{ID: primitive.NewObjectID()}, {ID: primitive.NewObjectID()}, {ID: primitive.NewObjectID()}}
+	for _, item := range items {
+		_, from, _ := c.get(item.ID)
+		c.put(item, from)
+	}
+	if _, _, ok := c.get(items[0].ID); ok {
+		t.Error("the least recently used blog was not evicted")
+	}
+	if _, _, ok := c.get(items[2].ID); !ok {
+		t.Error("the newest blog was evicted")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, _, ok := c.get(items[2].ID); ok {
+		t.Error("an expired blog was served")
+	}
+	st := c.stats()
+	if st.Evictions != 1 || st.Expirations != 1 || st.Size != 1 {
+		t.Errorf("stats = %+v, want 1 eviction, 1 expiration and 1 blog", st)
+	}
+}
+
+// pausedStore stops the first Get after it read the blog, until resumed
+type pausedStore struct {
+	BlogStore
+	once   sync.Once
+	read   chan struct{}
+	resume chan struct{}
+}
+
+func (s *pausedStore) Get(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	item, err := s.BlogStore.Get(ctx, id)
+	s.once.Do(func() {
+		s.read <- struct{}{}
+		<-s.resume
+	})
+	return item, err
+}
+
+func TestCachedStoreWriteDuringRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(s *cachedStore, item, other *blogItem) error
+		version int64 // of the blog read after the write
+		cached  bool
+	}{
+		{"update", func(s *cachedStore, item, other *blogItem) error {
+			_, err := s.Update(context.Background(), &blogItem{ID: item.ID, Title: "new", Version: item.Version}, []string{"title"})
+			return err
+		}, 2, false},
+		{"view of another blog", func(s *cachedStore, item, other *blogItem) error {
+			_, _, err := s.RecordView(context.Background(), other.ID, "viewer", time.Hour)
+			return err
+		}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := newMemoryStore()
+			item, _ := mem.Create(ctx, &blogItem{Title: "old"})
+			other, _ := mem.Create(ctx, &blogItem{Title: "other"})
+			paused := &pausedStore{BlogStore: mem, read: make(chan struct{}), resume: make(chan struct{})}
+			s := newCachedStore(paused, newBlogCache(10, time.Minute))
+
+			first := make(chan *blogItem)
+			go func() {
+				got, _ := s.Get(ctx, item.ID)
+				first <- got
+			}()
+			<-paused.read
+			if err := tt.write(s, item, other); err != nil {
+				t.Fatal(err)
+			}
+			// a reader coming after the write shares the read in flight,
+			// and must still see the write
+			second := make(chan *blogItem)
+			go func() {
+				got, _ := s.Get(ctx, item.ID)
+				second <- got
+			}()
+			time.Sleep(20 * time.Millisecond)
+			close(paused.resume)
+
+			if got := <-first; got.Version != 1 {
+				t.Errorf("the first read got version %v, want 1", got.Version)
+			}
+			if got := <-second; got.Version != tt.version {
+				t.Errorf("the read after the write got version %v, want %v", got.Version, tt.version)
+			}
+			if _, _, ok := s.cache.get(item.ID); ok != tt.cached {
+				t.Errorf("the blog is cached: %v, want %v", ok, tt.cached)
+			}
+		})
+	}
+}
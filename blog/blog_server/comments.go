@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type commentServer struct {
+	blogpb.UnimplementedCommentServiceServer
+
+	store CommentStore
+	// authors is where the authors of comments have to be known
+	authors AuthorStore
+}
+
+type commentItem struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID    primitive.ObjectID `bson:"blog_id"`
+	ParentID  primitive.ObjectID `bson:"parent_id,omitempty"`
+	AuthorID  string             `bson:"author_id"`
+	Content   string             `bson:"content"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+func (s *commentServer) AddComment(ctx context.Context, req *blogpb.AddCommentRequest) (*blogpb.AddCommentResponse, error) {
+	fmt.Println("Add comment request")
+
+	if err := validateAddComment(req); err != nil {
+		return nil, err
+	}
+	comment := req.GetComment()
+	blogID, err := parseBlogID(comment.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkAuthor(ctx, s.authors, comment.GetAuthorId()); err != nil {
+		return nil, err
+	}
+	data := &commentItem{
+		BlogID:    blogID,
+		AuthorID:  comment.GetAuthorId(),
+		Content:   comment.GetContent(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if comment.GetParentId() != "" {
+		if data.ParentID, err = parseCommentID(comment.GetParentId()); err != nil {
+			return nil, err
+		}
+	}
+
+	created, err := s.store.AddComment(ctx, data)
+	if err != nil {
+		if err == errCommentNotFound {
+			return nil, status.Errorf(
+				codes.NotFound,
+				fmt.Sprintf("Cannot find parent comment %v on blog %v", comment.GetParentId(), comment.GetBlogId()),
+			)
+		}
+		return nil, storeError(err, comment.GetBlogId())
+	}
+
+	return &blogpb.AddCommentResponse{
+		Comment: dataToCommentPb(created),
+	}, nil
+}
+
+func (s *commentServer) ListComments(req *blogpb.ListCommentsRequest, stream blogpb.CommentService_ListCommentsServer) error {
+	fmt.Printf("List comments request %v\n", req)
+
+	blogID, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return err
+	}
+	root := primitive.NilObjectID
+	if req.GetParentId() != "" {
+		if root, err = parseCommentID(req.GetParentId()); err != nil {
+			return err
+		}
+	}
+
+	// comments come oldest first, so appending keeps every list of
+	// replies in the order they were written
+	replies := make(map[primitive.ObjectID][]*commentItem)
+	err = s.store.ListComments(stream.Context(), blogID, func(c *commentItem) error {
+		replies[c.ParentID] = append(replies[c.ParentID], c)
+		return nil
+	})
+	if err != nil {
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error: %v", err),
+		)
+	}
+
+	var walk func(parent primitive.ObjectID, depth int32) error
+	walk = func(parent primitive.ObjectID, depth int32) error {
+		for _, c := range replies[parent] {
+			if err := stream.Send(&blogpb.ListCommentsResponse{
+				Comment: dataToCommentPb(c),
+				Depth:   depth,
+			}); err != nil {
+				return err
+			}
+			if err := walk(c.ID, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, 0)
+}
+
+func (s *commentServer) DeleteComment(ctx context.Context, req *blogpb.DeleteCommentRequest) (*blogpb.DeleteCommentResponse, error) {
+	fmt.Println("Delete comment request")
+
+	oid, err := parseCommentID(req.GetCommentId())
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := s.store.DeleteComment(ctx, oid)
+	if err != nil {
+		if err == errCommentNotFound {
+			return nil, status.Errorf(
+				codes.NotFound,
+				fmt.Sprintf("Cannot find comment with specified ID: %v", req.GetCommentId()),
+			)
+		}
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+
+	return &blogpb.DeleteCommentResponse{
+		CommentId:    req.GetCommentId(),
+		DeletedCount: int32(n),
+	}, nil
+}
+
+func parseCommentID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Cannot parse comment ID: %v", id),
+		)
+	}
+	return oid, nil
+}
+
+func dataToCommentPb(data *commentItem) *blogpb.Comment {
+	c := &blogpb.Comment{
+		Id:        data.ID.Hex(),
+		BlogId:    data.BlogID.Hex(),
+		AuthorId:  data.AuthorID,
+		Content:   data.Content,
+		CreatedAt: timestamppb.New(data.CreatedAt),
+	}
+	if !data.ParentID.IsZero() {
+		c.ParentId = data.ParentID.Hex()
+	}
+	return c
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"grpc-go-course/blog/blogpb"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAddComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment func(c *blogpb.Comment) // changes a valid comment
+		code    codes.Code
+	}{
+		{"valid", func(c *blogpb.Comment) {}, codes.OK},
+		{"empty", func(c *blogpb.Comment) { c.Content = "" }, codes.InvalidArgument},
+		{"blank", func(c *blogpb.Comment) { c.Content = " \n\t" }, codes.InvalidArgument},
+		{"control characters", func(c *blogpb.Comment) { c.Content = "nice\x00" }, codes.InvalidArgument},
+		{"too large", func(c *blogpb.Comment) { c.Content = strings.Repeat("a", maxCommentBytes+1) }, codes.InvalidArgument},
+		{"no author", func(c *blogpb.Comment) { c.AuthorId = "" }, codes.InvalidArgument},
+		{"unknown author", func(c *blogpb.Comment) { c.AuthorId = primitive.NewObjectID().Hex() }, codes.FailedPrecondition},
+		{"unknown parent", func(c *blogpb.Comment) { c.ParentId = primitive.NewObjectID().Hex() }, codes.NotFound},
+		{"unknown blog", func(c *blogpb.Comment) { c.BlogId = primitive.NewObjectID().Hex() }, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			s := &commentServer{store: tenantStore{}, authors: tenantStore{}}
+			blog, err := (tenantStore{}).Create(ctx, &blogItem{Title: "t", Status: statusPublished})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			comment := &blogpb.Comment{BlogId: blog.ID.Hex(), AuthorId: testAuthor(t, ctx), Content: "Nice post"}
+			tt.comment(comment)
+			_, err = s.AddComment(ctx, &blogpb.AddCommentRequest{Comment: comment})
+			if status.Code(err) != tt.code {
+				t.Errorf("AddComment failed with %v, want %v", err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"grpc-go-course/blog/blogpb"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// listCursor is the state behind the opaque cursor handed out by ListBlogs.
+// It records the last blog sent and the order it was sent in, so a resumed
+// stream can pick up right after it.
+type listCursor struct {
+	Order  blogpb.ListBlogsRequest_SortOrder `json:"o"`
+	LastID string                            `json:"id"`
+}
+
+func encodeCursor(order blogpb.ListBlogsRequest_SortOrder, lastID primitive.ObjectID) string {
+	b, _ := json.Marshal(listCursor{Order: order, LastID: lastID.Hex()})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the id to resume after. An empty cursor starts from
+// the beginning and yields primitive.NilObjectID.
+func decodeCursor(cursor string, order blogpb.ListBlogsRequest_SortOrder) (primitive.ObjectID, error) {
+	if cursor == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	invalid := fieldError("cursor", "is not a cursor issued by ListBlogs: %v", cursor)
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return primitive.NilObjectID, invalid
+	}
+	c := listCursor{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return primitive.NilObjectID, invalid
+	}
+	if c.Order != order {
+		return primitive.NilObjectID, fieldError("cursor", "was issued for sort order %v, not %v", c.Order, order)
+	}
+	oid, err := primitive.ObjectIDFromHex(c.LastID)
+	if err != nil {
+		return primitive.NilObjectID, invalid
+	}
+	return oid, nil
+}
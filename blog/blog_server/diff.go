@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxDiffLines bounds the lines of each side of a diff, since the time to
+// diff grows with the lines times the number of differences
+const maxDiffLines = 10000
+
+// diffLine is one line of an edit script: ' ' kept, '-' removed, '+' added
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns a line level diff from a to b in unified format, or
+// an empty string when they are equal
+func unifiedDiff(fromName, toName, a, b string) string {
+	script := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	for _, h := range hunks(script) {
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %v\n+++ %v\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%v +%v @@\n", hunkRange(h.aStart, h.aLen), hunkRange(h.bStart, h.bLen))
+		for _, l := range script[h.lo:h.hi] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script with the linear space variant
+// of Myers' algorithm, which finds the middle of an optimal path and
+// recurses on both sides of it
+func diffLines(a, b []string) []diffLine {
+	size := len(a) + len(b) + 2
+	d := &differ{a: a, b: b, fwd: make([]int, 2*size), bwd: make([]int, 2*size), offset: size}
+	d.compare(0, len(a), 0, len(b))
+	return d.script
+}
+
+// differ holds the inputs of diffLines, the frontiers of both searches,
+// which every step of the recursion reuses, and the script so far
+type differ struct {
+	a, b     []string
+	fwd, bwd []int // furthest x on each diagonal, indexed by offset+k
+	offset   int
+	script   []diffLine
+}
+
+// compare appends the edit script from a[aLo:aHi] to b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.script = append(d.script, diffLine{' ', d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.script = append(d.script, diffLine{'+', line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.script = append(d.script, diffLine{'-', line})
+		}
+	default:
+		// both sides differ at their ends now, so the middle snake leaves
+		// a shorter script to find on either side of it
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for _, line := range d.a[x:u] {
+			d.script = append(d.script, diffLine{' ', line})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for _, line := range d.a[aHi : aHi+suffix] {
+		d.script = append(d.script, diffLine{' ', line})
+	}
+}
+
+// middleSnake searches from both ends of a[aLo:aHi] and b[bLo:bHi] at once
+// until the searches meet, and returns the run of equal lines from (x, y)
+// to (u, v) where they do
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	fwd, bwd, off := d.fwd, d.bwd, d.offset
+	fwd[off+1], bwd[off+1] = 0, 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+			// the backward search runs on diagonals delta-k, and is one
+			// step behind here
+			if c := delta - k; odd && c >= -(step-1) && c <= step-1 && x+bwd[off+c] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+
+		// the backward search counts x and y from the ends
+		for c := -step; c <= step; c += 2 {
+			var x int
+			if c == -step || (c != step && bwd[off+c-1] < bwd[off+c+1]) {
+				x = bwd[off+c+1]
+			} else {
+				x = bwd[off+c-1] + 1
+			}
+			y := x - c
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			bwd[off+c] = x
+			if k := delta - c; !odd && k >= -step && k <= step && x+fwd[off+k] >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	panic("diff: the searches did not meet")
+}
+
+// hunk is a run of the edit script with its position in both inputs
+type hunk struct {
+	lo, hi       int // script[lo:hi]
+	aStart, aLen int
+	bStart, bLen int
+}
+
+// hunks groups the changes of script together with diffContext lines
+// around them, merging changes that are close to each other
+func hunks(script []diffLine) []hunk {
+	var hs []hunk
+	for i := 0; i < len(script); i++ {
+		if script[i].op == ' ' {
+			continue
+		}
+		lo := i - diffContext
+		if lo < 0 {
+			lo = 0
+		}
+		// extend past every change that is followed by another one with at
+		// most twice the context of unchanged lines in between
+		hi := i
+		for j := i; j < len(script) && j <= hi+2*diffContext+1; j++ {
+			if script[j].op != ' ' {
+				hi = j
+			}
+		}
+		hi += diffContext + 1
+		if hi > len(script) {
+			hi = len(script)
+		}
+		hs = append(hs, hunk{lo: lo, hi: hi})
+		i = hi - 1
+	}
+
+	// line numbers are counted from the start of the script
+	aLine, bLine, pos := 0, 0, 0
+	for idx := range hs {
+		h := &hs[idx]
+		for ; pos < h.lo; pos++ {
+			aLine, bLine = advance(script[pos].op, aLine, bLine)
+		}
+		h.aStart, h.bStart = aLine, bLine
+		for ; pos < h.hi; pos++ {
+			aLine, bLine = advance(script[pos].op, aLine, bLine)
+		}
+		h.aLen, h.bLen = aLine-h.aStart, bLine-h.bStart
+	}
+	return hs
+}
+
+func advance(op byte, aLine, bLine int) (int, int) {
+	if op != '+' {
+		aLine++
+	}
+	if op != '-' {
+		bLine++
+	}
+	return aLine, bLine
+}
+
+// hunkRange formats a hunk header range, which is 1-based and, for an
+// empty range, names the line before it
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, length)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"added", "", "a\n", "--- from\n+++ to\n@@ -0,0 +1 @@\n+a\n"},
+		{"removed", "a\n", "", "--- from\n+++ to\n@@ -1 +0,0 @@\n-a\n"},
+		{"changed", "a\nb\nc\n", "a\nx\nc\n", "--- from\n+++ to\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{
+			"twice the context apart",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"1\nX\n3\n4\n5\n6\n7\n8\nY\n",
+			"--- from\n+++ to\n@@ -1,9 +1,9 @@\n 1\n-2\n+X\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+Y\n",
+		},
+		{
+			"further apart",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\nX\n3\n4\n5\n6\n7\n8\n9\nY\n",
+			"--- from\n+++ to\n@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+Y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("from", "to", tt.a, tt.b); got != tt.want {
+				t.Errorf("unifiedDiff(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := func(n int, prefix string) []string {
+		var out []string
+		for i := 0; i < n; i++ {
+			out = append(out, fmt.Sprintf("%v%v", prefix, i))
+		}
+		return out
+	}
+	tests := []struct {
+		name  string
+		a, b  []string
+		edits int
+	}{
+		{"empty", nil, nil, 0},
+		{"insert", []string{"a", "c"}, []string{"a", "b", "c"}, 1},
+		{"swap", []string{"a", "b"}, []string{"b", "a"}, 2},
+		{"interleaved", strings.Split("abcabba", ""), strings.Split("cbabac", ""), 5},
+		{"disjoint", lines(maxDiffLines, "a"), lines(maxDiffLines, "b"), 2 * maxDiffLines},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := diffLines(tt.a, tt.b)
+			var a, b []string
+			edits := 0
+			for _, l := range script {
+				if l.op != '+' {
+					a = append(a, l.text)
+				}
+				if l.op != '-' {
+					b = append(b, l.text)
+				}
+				if l.op != ' ' {
+					edits++
+				}
+			}
+			if strings.Join(a, "\n") != strings.Join(tt.a, "\n") || strings.Join(b, "\n") != strings.Join(tt.b, "\n") {
+				t.Fatalf("script does not turn %q into %q", tt.a, tt.b)
+			}
+			if edits != tt.edits {
+				t.Errorf("script has %v edits, want %v", edits, tt.edits)
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// feedItems is how many of the newest blogs a feed carries
+	feedItems = 20
+	// maxSitemapURLs is as many URLs as one sitemap may list
+	maxSitemapURLs = 50000
+	// maxFeedDocs is as many built documents as a feedServer keeps
+	maxFeedDocs = 1000
+	// tenantHeader and tenantParam name the tenant of an HTTP request,
+	// the query parameter being for feed readers that cannot set headers
+	tenantHeader = "Tenant-Id"
+	tenantParam  = "tenant"
+)
+
+// feedServer serves RSS 2.0 and Atom feeds of the published blogs, all of
+// them or by author, and a sitemap of them over HTTP, reading the same
+// tenant stores the gRPC services write to.
+//
+// Responses carry an ETag made from their content, and a Last-Modified
+// that is when the newest of the blogs in them was published or updated,
+// so conditional requests get 304 Not Modified. A blog taken down can move
+// Last-Modified back, which the ETag, checked first, still catches.
+// Documents are built once for every write and kept for at most maxAge, which bounds how long changes
+// the change feed does not carry, such as a renamed author or the writes
+// of other servers sharing a Mongo database, take to show.
+type feedServer struct {
+	tenants tenants
+	store   BlogStore
+	// title and publicURL are the defaults for tenants that set none;
+	// without a public URL, links point at the host the request came to
+	title     string
+	publicURL string
+	maxAge    time.Duration // 0 builds every document for every request
+
+	mu   sync.Mutex
+	docs map[string]*feedDoc // by tenant and URL
+}
+
+// feedDoc is a built document along with what it was built from
+type feedDoc struct {
+	seq      int64     // newest change to the blogs of the tenant
+	modified time.Time // newest publish or update time of the blogs in it
+	built    time.Time
+	etag     string
+	body     []byte
+}
+
+func (s *feedServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.rss", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("RSS feed request")
+		s.serve(w, r, "application/rss+xml; charset=utf-8", s.rss(""))
+	})
+	mux.HandleFunc("/feed.atom", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("Atom feed request")
+		s.serve(w, r, "application/atom+xml; charset=utf-8", s.atom(""))
+	})
+	mux.HandleFunc("/authors/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("Author feed request")
+		// /authors/<author id>/feed.rss or feed.atom
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/authors/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		switch parts[1] {
+		case "feed.rss":
+			s.serve(w, r, "application/rss+xml; charset=utf-8", s.rss(parts[0]))
+		case "feed.atom":
+			s.serve(w, r, "application/atom+xml; charset=utf-8", s.atom(parts[0]))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("Sitemap request")
+		s.serve(w, r, "application/xml; charset=utf-8", s.sitemap)
+	})
+	return mux
+}
+
+// feedSite is what a document is built for: the tenant a request is for
+// and where its blogs are found
+type feedSite struct {
+	ctx   context.Context
+	title string
+	base  string // public URL without a trailing slash
+	self  string // URL of the document itself
+}
+
+func (site *feedSite) blogLink(item *blogItem) string {
+	slug := item.slug()
+	if slug == "" {
+		slug = item.ID.Hex()
+	}
+	return site.base + "/blogs/" + slug
+}
+
+// serve resolves the tenant of r, builds the document and writes it,
+// answering conditional requests with 304 Not Modified
+func (s *feedServer) serve(w http.ResponseWriter, r *http.Request, contentType string, build func(site *feedSite) ([]byte, time.Time, error)) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.Header.Get(tenantHeader)
+	if id == "" {
+		id = r.URL.Query().Get(tenantParam)
+	}
+	ctx, err := s.tenants.withTenant(r.Context(), id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	t, err := tenantFrom(ctx)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	// read before building, so a write made meanwhile is never hidden
+	// behind an older document
+	seq := t.feed.head()
+
+	site := &feedSite{ctx: ctx, title: s.title, base: s.publicURL}
+	if t.config.Title != "" {
+		site.title = t.config.Title
+	}
+	if t.config.PublicURL != "" {
+		site.base = t.config.PublicURL
+	}
+	if site.base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		site.base = scheme + "://" + r.Host
+	}
+	site.base = strings.TrimSuffix(site.base, "/")
+	site.self = site.base + r.URL.RequestURI()
+
+	key := strings.Join([]string{id, site.title, site.self}, "\x00")
+	doc := s.doc(key, seq)
+	if doc == nil {
+		body, modified, err := build(site)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		sum := sha256.Sum256(body)
+		doc = &feedDoc{
+			seq:      seq,
+			modified: modified,
+			built:    time.Now(),
+			etag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+			body:     body,
+		}
+		s.keep(key, doc)
+	}
+
+	modified := doc.modified
+	if time.Since(modified) < time.Second {
+		// Last-Modified counts whole seconds, so it could not tell this
+		// change apart from one later in the same second; leave it to the
+		// ETag until the second is over
+		modified = time.Time{}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", doc.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", modified, bytes.NewReader(doc.body))
+}
+
+// doc returns the document kept under key if it was built after change
+// seq and is not too old, and nil otherwise
+func (s *feedServer) doc(key string, seq int64) *feedDoc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.docs[key]
+	if !ok || doc.seq != seq || time.Since(doc.built) >= s.maxAge {
+		return nil
+	}
+	return doc
+}
+
+// keep keeps doc under key, making room by dropping old documents, or any
+// one if none is old
+func (s *feedServer) keep(key string, doc *feedDoc) {
+	if s.maxAge <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.docs == nil {
+		s.docs = make(map[string]*feedDoc)
+	}
+	if _, ok := s.docs[key]; !ok && len(s.docs) >= maxFeedDocs {
+		for k, old := range s.docs {
+			if time.Since(old.built) >= s.maxAge {
+				delete(s.docs, k)
+			}
+		}
+		for k := range s.docs {
+			if len(s.docs) < maxFeedDocs {
+				break
+			}
+			delete(s.docs, k)
+		}
+	}
+	s.docs[key] = doc
+}
+
+// httpError writes err, a gRPC status or a store error, as an HTTP error
+func httpError(w http.ResponseWriter, err error) {
+	if err == errNotFound || err == errAuthorNotFound {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		log.Printf("Feed request failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	switch st.Code() {
+	case codes.InvalidArgument:
+		http.Error(w, st.Message(), http.StatusBadRequest)
+	case codes.NotFound, codes.PermissionDenied:
+		http.Error(w, st.Message(), http.StatusNotFound)
+	default:
+		log.Printf("Feed request failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// feedBlog is a blog in a feed along with its author, nil if unknown
+type feedBlog struct {
+	item   *blogItem
+	author *authorItem
+}
+
+// newest returns the most recently published blogs, by author unless it
+// is nil, along with their authors
+func (s *feedServer) newest(ctx context.Context, author *authorItem) ([]feedBlog, error) {
+	opts := listOptions{
+		Descending:    true,
+		ByPublishTime: true,
+		Limit:         feedItems,
+		Statuses:      []string{statusPublished},
+	}
+	if author != nil {
+		opts.AuthorID = author.ID.Hex()
+	}
+
+	authors := make(map[string]*authorItem)
+	if author != nil {
+		authors[opts.AuthorID] = author
+	}
+	var blogs []feedBlog
+	err := s.store.List(ctx, opts, func(item *blogItem) error {
+		a, ok := authors[item.AuthorID]
+		if !ok {
+			if oid, err := primitive.ObjectIDFromHex(item.AuthorID); err == nil {
+				a, _ = s.store.GetAuthor(ctx, oid)
+			}
+			authors[item.AuthorID] = a
+		}
+		blogs = append(blogs, feedBlog{item: item, author: a})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blogs, nil
+}
+
+// feedAuthor returns the author a feed is for, nil for a feed of every
+// author
+func (s *feedServer) feedAuthor(ctx context.Context, authorID string) (*authorItem, error) {
+	if authorID == "" {
+		return nil, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(authorID)
+	if err != nil {
+		return nil, errAuthorNotFound
+	}
+	return s.store.GetAuthor(ctx, oid)
+}
+
+// updated returns when the newest of blogs was published or updated, or
+// the zero time if there are none
+func updated(blogs []feedBlog) time.Time {
+	var t time.Time
+	for _, b := range blogs {
+		if at := b.item.modifiedAt(); at.After(t) {
+			t = at
+		}
+	}
+	return t.UTC()
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Author      string   `xml:"author,omitempty"`
+	Categories  []string `xml:"category"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// rss builds the RSS feed of authorID, or of every author if it is empty
+func (s *feedServer) rss(authorID string) func(site *feedSite) ([]byte, time.Time, error) {
+	return func(site *feedSite) ([]byte, time.Time, error) {
+		author, err := s.feedAuthor(site.ctx, authorID)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		blogs, err := s.newest(site.ctx, author)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		channel := rssChannel{
+			Title:       site.title,
+			Link:        site.base + "/",
+			Description: "The newest blogs of " + site.title,
+		}
+		if author != nil {
+			channel.Title = site.title + ": " + author.DisplayName
+			channel.Description = "The newest blogs by " + author.DisplayName
+		}
+		t := updated(blogs)
+		if !t.IsZero() {
+			channel.LastBuildDate = t.Format(time.RFC1123Z)
+		}
+		for _, b := range blogs {
+			item := rssItem{
+				Title:       b.item.Title,
+				Link:        site.blogLink(b.item),
+				Description: renderMarkdown(b.item.Content).HTML,
+				Categories:  b.item.Tags,
+				GUID:        rssGUID{ID: b.item.ID.Hex()},
+				PubDate:     b.item.publishedAt().UTC().Format(time.RFC1123Z),
+			}
+			// RSS wants an email address for the author, with the name
+			// in parentheses
+			if b.author != nil && b.author.Email != "" {
+				item.Author = fmt.Sprintf("%v (%v)", b.author.Email, b.author.DisplayName)
+			}
+			channel.Items = append(channel.Items, item)
+		}
+		body, err := marshalXML(rssFeed{Version: "2.0", Channel: channel})
+		return body, t, err
+	}
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomPerson `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Links      []atomLink     `xml:"link"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// atom builds the Atom feed of authorID, or of every author if it is empty
+func (s *feedServer) atom(authorID string) func(site *feedSite) ([]byte, time.Time, error) {
+	return func(site *feedSite) ([]byte, time.Time, error) {
+		author, err := s.feedAuthor(site.ctx, authorID)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		blogs, err := s.newest(site.ctx, author)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		feed := atomFeed{
+			Title: site.title,
+			ID:    site.self,
+			Links: []atomLink{
+				{Rel: "self", Href: site.self},
+				{Rel: "alternate", Href: site.base + "/"},
+			},
+		}
+		if author != nil {
+			feed.Title = site.title + ": " + author.DisplayName
+			feed.Author = &atomPerson{Name: author.DisplayName, Email: author.Email}
+		}
+		// a feed with no entries was last updated when its author was made,
+		// or the epoch for a site with no blogs yet
+		t := updated(blogs)
+		if t.IsZero() && author != nil {
+			t = author.CreatedAt.UTC()
+		}
+		feed.Updated = t.Format(time.RFC3339)
+		if t.IsZero() {
+			feed.Updated = time.Unix(0, 0).UTC().Format(time.RFC3339)
+		}
+
+		for _, b := range blogs {
+			entry := atomEntry{
+				Title:     b.item.Title,
+				ID:        site.base + "/blogs/" + b.item.ID.Hex(),
+				Updated:   b.item.modifiedAt().UTC().Format(time.RFC3339),
+				Published: b.item.publishedAt().UTC().Format(time.RFC3339),
+				Links:     []atomLink{{Rel: "alternate", Href: site.blogLink(b.item)}},
+				Author:    atomPerson{Name: b.item.AuthorID},
+				Content:   atomContent{Type: "html", Body: renderMarkdown(b.item.Content).HTML},
+			}
+			if b.author != nil {
+				entry.Author = atomPerson{Name: b.author.DisplayName, Email: b.author.Email}
+			}
+			for _, tag := range b.item.Tags {
+				entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+		body, err := marshalXML(feed)
+		return body, t, err
+	}
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemap builds a sitemap of every published blog, newest first
+func (s *feedServer) sitemap(site *feedSite) ([]byte, time.Time, error) {
+	set := sitemapURLSet{}
+	opts := listOptions{
+		Descending: true,
+		Limit:      maxSitemapURLs,
+		Statuses:   []string{statusPublished},
+		Fields:     []string{"slugs", "publish_at", "created_at", "updated_at"},
+	}
+	var newest time.Time
+	err := s.store.List(site.ctx, opts, func(item *blogItem) error {
+		modified := item.modifiedAt().UTC()
+		if modified.After(newest) {
+			newest = modified
+		}
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     site.blogLink(item),
+			LastMod: modified.Format(time.RFC3339),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := marshalXML(set)
+	return body, newest, err
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
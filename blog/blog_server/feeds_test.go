@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFeedsFollowUpdates(t *testing.T) {
+	s := &feedServer{store: tenantStore{}}
+	tests := []struct {
+		name   string
+		build  func(site *feedSite) ([]byte, time.Time, error)
+		layout string // of the times in the document
+	}{
+		{"rss", s.rss(""), time.RFC1123Z},
+		{"atom", s.atom(""), time.RFC3339},
+		{"sitemap", s.sitemap, time.RFC3339},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			site := &feedSite{ctx: ctx, title: "Blog", base: "http://blog.test", self: "http://blog.test/feed"}
+			published := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+			item, err := (tenantStore{}).Create(ctx, &blogItem{Title: "t", Status: statusPublished, PublishAt: &published})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, modified, err := tt.build(site); err != nil || !modified.Equal(published) {
+				t.Fatalf("built a document modified at %v, %v, want %v", modified, err, published)
+			}
+
+			updated, err := tenantStore{}.Update(ctx, &blogItem{ID: item.ID, Content: "edit", Version: item.Version}, []string{"content"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, modified, err := tt.build(site)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !modified.Equal(updated.UpdatedAt) {
+				t.Errorf("built a document modified at %v, want the update at %v", modified, updated.UpdatedAt)
+			}
+			if !bytes.Contains(body, []byte(updated.UpdatedAt.Format(tt.layout))) {
+				t.Errorf("the document does not show the update at %v:\n%s", updated.UpdatedAt, body)
+			}
+		})
+	}
+}
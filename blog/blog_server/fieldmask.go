@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// blogReadFields maps each field of the Blog message to the stored fields
+// it is made from, for projecting reads. The id always comes back.
+var blogReadFields = map[string][]string{
+	"id":          nil,
+	"author_id":   {"author_id"},
+	"title":       {"title"},
+	"content":     {"content"},
+	"version":     {"version"},
+	"deleted_at":  {"deleted_at"},
+	"tags":        {"tags"},
+	"category":    {"category"},
+	"author_name": {"author_id"},
+	"status":      {"status"},
+	"publish_at":  {"publish_at"},
+	"slug":        {"slugs"},
+	"reactions":   {"reactions"},
+	"view_count":  {"views"},
+	"created_at":  {"created_at"},
+}
+
+// readMask checks that every path of mask is a top-level field of Blog
+func (v *violations) readMask(field string, mask *fieldmaskpb.FieldMask) {
+	for i, path := range mask.GetPaths() {
+		if _, ok := blogReadFields[path]; !ok {
+			v.add(fmt.Sprintf("%v.paths[%v]", field, i), "is not a field of Blog: %q", path)
+		}
+	}
+}
+
+// updateMask checks that every path of mask is a field UpdateBlog writes
+func (v *violations) updateMask(field string, mask *fieldmaskpb.FieldMask) {
+	for i, path := range mask.GetPaths() {
+		if !contains(blogFields, path) {
+			v.add(fmt.Sprintf("%v.paths[%v]", field, i), "is not a field UpdateBlog writes: %q, expected one of %v", path, blogFields)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// updateFields returns the fields an update mask asks to write, nil for
+// all of them
+func updateFields(mask *fieldmaskpb.FieldMask) []string {
+	if len(mask.GetPaths()) == 0 {
+		return nil
+	}
+	return mask.GetPaths()
+}
+
+// storedFields returns the stored fields a read mask needs, nil for all of
+// them
+func storedFields(mask *fieldmaskpb.FieldMask) []string {
+	if len(mask.GetPaths()) == 0 {
+		return nil
+	}
+	var fields []string
+	for _, path := range mask.GetPaths() {
+		for _, f := range blogReadFields[path] {
+			if !contains(fields, f) {
+				fields = append(fields, f)
+			}
+		}
+	}
+	if len(fields) == 0 {
+		// only the id was asked for
+		fields = []string{"_id"}
+	}
+	return fields
+}
+
+// applyReadMask clears the fields of blog that mask leaves out. An empty
+// mask keeps them all.
+func applyReadMask(blog *blogpb.Blog, mask *fieldmaskpb.FieldMask) {
+	if len(mask.GetPaths()) == 0 {
+		return
+	}
+	m := blog.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if fd := fields.Get(i); !contains(mask.GetPaths(), string(fd.Name())) {
+			m.Clear(fd)
+		}
+	}
+}
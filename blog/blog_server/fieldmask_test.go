@@ -0,0 +1,109 @@
+package main
+
+import (
+	"grpc-go-course/blog/blogpb"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func violationFields(v violations) []string {
+	var fields []string
+	for _, fv := range v {
+		fields = append(fields, fv.GetField())
+	}
+	return fields
+}
+
+func TestMasks(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  func(v *violations, field string, mask *fieldmaskpb.FieldMask)
+		paths  []string
+		errors []string
+	}{
+		{"read, empty", (*violations).readMask, nil, nil},
+		{"read, every field", (*violations).readMask, []string{"id", "title", "author_name", "view_count", "created_at"}, nil},
+		{"read, unknown", (*violations).readMask, []string{"title", "titel", "blog.title"}, []string{"mask.paths[1]", "mask.paths[2]"}},
+		{"update, writable", (*violations).updateMask, []string{"title", "tags"}, nil},
+		{"update, read only", (*violations).updateMask, []string{"version", "title", "slug"}, []string{"mask.paths[0]", "mask.paths[2]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := violations{}
+			tt.check(&v, "mask", &fieldmaskpb.FieldMask{Paths: tt.paths})
+			if got := violationFields(v); !reflect.DeepEqual(got, tt.errors) {
+				t.Errorf("violations = %v, want %v", got, tt.errors)
+			}
+		})
+	}
+}
+
+func TestStoredFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		paths  []string
+		fields []string
+	}{
+		{"no mask", nil, nil},
+		{"id only", []string{"id"}, []string{"_id"}},
+		{"renamed", []string{"slug", "view_count"}, []string{"slugs", "views"}},
+		{"shared", []string{"author_id", "author_name", "title"}, []string{"author_id", "title"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mask *fieldmaskpb.FieldMask
+			if tt.paths != nil {
+				mask = &fieldmaskpb.FieldMask{Paths: tt.paths}
+			}
+			if got := storedFields(mask); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("storedFields(%v) = %v, want %v", tt.paths, got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestApplyReadMask(t *testing.T) {
+	full := &blogpb.Blog{Id: "id", AuthorId: "a", Title: "t", Content: "c", Tags: []string{"x"}, Version: 3}
+	tests := []struct {
+		name  string
+		paths []string
+		want  *blogpb.Blog
+	}{
+		{"no mask", nil, full},
+		{"some fields", []string{"id", "title", "tags"}, &blogpb.Blog{Id: "id", Title: "t", Tags: []string{"x"}}},
+		{"unset field", []string{"category"}, &blogpb.Blog{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blog := proto.Clone(full).(*blogpb.Blog)
+			applyReadMask(blog, &fieldmaskpb.FieldMask{Paths: tt.paths})
+			if !proto.Equal(blog, tt.want) {
+				t.Errorf("applyReadMask(%v) = %v, want %v", tt.paths, blog, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	old := &blogItem{AuthorID: "a", Title: "old title", Content: "old content", Tags: []string{"old"}, Version: 2}
+	item := &blogItem{AuthorID: "b", Title: "new title", Content: "", Version: 2}
+	tests := []struct {
+		name   string
+		fields []string
+		want   blogItem
+	}{
+		{"every field", nil, blogItem{AuthorID: "b", Title: "new title", Version: 2}},
+		{"title only", []string{"title"}, blogItem{AuthorID: "a", Title: "new title", Content: "old content", Tags: []string{"old"}, Version: 2}},
+		{"cleared content", []string{"content"}, blogItem{AuthorID: "a", Title: "old title", Tags: []string{"old"}, Version: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := old.withFields(item, tt.fields); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("withFields(%v) = %+v, want %+v", tt.fields, *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// idempotencyMetadata is the metadata key a client can send an idempotency
+// key in instead of the request field
+const idempotencyMetadata = "idempotency-key"
+
+const maxIdempotencyKeyLength = 200
+
+// idempotencyItem remembers the blog created for an idempotency key until
+// it expires, so a retried CreateBlog gets the same response back
+type idempotencyItem struct {
+	Key string `bson:"_id"`
+	// Hash identifies the request the key was first used with
+	Hash string `bson:"hash"`
+	// Blog is the blog as it was created, which is what the original
+	// response held
+	Blog *blogItem `bson:"blog"`
+	// Pending marks a key whose blog, to get id BlogID, is still being
+	// created; Blog is only set once it was
+	Pending   bool               `bson:"pending,omitempty"`
+	BlogID    primitive.ObjectID `bson:"blog_id,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+func (key *idempotencyItem) expired(now time.Time) bool {
+	return !now.Before(key.ExpiresAt)
+}
+
+// idempotencyKey returns the key a CreateBlog request carries, from the
+// request itself or else from the metadata, or "" if it has none
+func idempotencyKey(ctx context.Context, req *blogpb.CreateBlogRequest) (string, error) {
+	var fromMD string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(idempotencyMetadata); len(values) > 0 {
+			fromMD = values[0]
+		}
+	}
+
+	key := req.GetIdempotencyKey()
+	switch {
+	case key == "":
+		key = fromMD
+	case fromMD != "" && fromMD != key:
+		return "", fieldError("idempotency_key", "differs from the %v metadata", idempotencyMetadata)
+	}
+
+	v := violations{}
+	v.line("idempotency_key", key, false, maxIdempotencyKeyLength)
+	return key, v.err()
+}
+
+// requestHash fingerprints the blog a CreateBlog request asks for, so a
+// key reused for a different blog can be told apart from a retry
+func requestHash(blog *blogpb.Blog) string {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(blog)
+	if err != nil {
+		// cannot happen for a message that was just unmarshaled
+		b = []byte(blog.String())
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// createOnce creates data for a request with an idempotency key, or
+// returns the blog created by an earlier request with the same key
+func (s *server) createOnce(ctx context.Context, data *blogItem, key string, blog *blogpb.Blog) (*blogItem, error) {
+	hash := requestHash(blog)
+	created, prior, err := s.store.CreateOnce(ctx, data, &idempotencyItem{
+		Key:       key,
+		Hash:      hash,
+		ExpiresAt: time.Now().UTC().Add(s.idempotencyWindow),
+	})
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+	if prior == nil {
+		return created, nil
+	}
+
+	if prior.Hash != hash {
+		return nil, status.Errorf(
+			codes.AlreadyExists,
+			fmt.Sprintf("Idempotency key %q was already used for a different blog", key),
+		)
+	}
+	if prior.Pending {
+		return nil, status.Errorf(
+			codes.Aborted,
+			fmt.Sprintf("The blog for idempotency key %q is still being created, retry the request", key),
+		)
+	}
+	fmt.Printf("Replaying the response for idempotency key %q\n", key)
+	return prior.Blog, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCreateBlogIdempotency(t *testing.T) {
+	tests := []struct {
+		name   string
+		retry  func(blog *blogpb.Blog) // changes the blog of the retry
+		key    string                  // of the retry
+		code   codes.Code
+		replay bool // whether the retry gets the first response back
+	}{
+		{"same request", func(*blogpb.Blog) {}, "k1", codes.OK, true},
+		{"different blog", func(b *blogpb.Blog) { b.Title = "other" }, "k1", codes.AlreadyExists, false},
+		{"different key", func(*blogpb.Blog) {}, "k2", codes.OK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			s := &server{store: tenantStore{}, idempotencyWindow: time.Hour}
+			blog := &blogpb.Blog{AuthorId: testAuthor(t, ctx), Title: "Hello world", Content: "c", Tags: []string{"go"}}
+
+			first, err := s.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: blog, IdempotencyKey: "k1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.GetBlog().GetSlug() == "" || first.GetBlog().GetCreatedAt() == nil {
+				t.Fatalf("the first response has no slug or created_at: %v", first)
+			}
+
+			retry := proto.Clone(blog).(*blogpb.Blog)
+			tt.retry(retry)
+			second, err := s.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: retry, IdempotencyKey: tt.key})
+			if status.Code(err) != tt.code {
+				t.Fatalf("retry failed with %v, want %v", err, tt.code)
+			}
+			if err != nil {
+				return
+			}
+			if got := proto.Equal(first, second); got != tt.replay {
+				t.Errorf("retry got the first response: %v, want %v\nfirst:  %v\nsecond: %v", got, tt.replay, first, second)
+			}
+		})
+	}
+}
+
+// pendingStore has every idempotency key still being used by another call
+type pendingStore struct {
+	BlogStore
+	hash string
+}
+
+func (s pendingStore) CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error) {
+	return nil, &idempotencyItem{Key: key.Key, Hash: s.hash, Pending: true}, nil
+}
+
+func TestCreateBlogPendingKey(t *testing.T) {
+	blog := &blogpb.Blog{Title: "t"}
+	s := &server{store: pendingStore{hash: requestHash(blog)}, idempotencyWindow: time.Hour}
+	_, err := s.createOnce(context.Background(), &blogItem{Title: "t"}, "k", blog)
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("createOnce with a pending key failed with %v, want Aborted", err)
+	}
+}
+
+func TestMongoCreateOnceReplay(t *testing.T) {
+	m, _, cleanup := testMongoStore(t, false)
+	defer cleanup()
+	ctx := context.Background()
+	key := &idempotencyItem{Key: "k", Hash: "h", ExpiresAt: time.Now().Add(time.Hour)}
+
+	created, prior, err := m.CreateOnce(ctx, &blogItem{Title: "Hello world"}, key)
+	if err != nil || prior != nil {
+		t.Fatalf("CreateOnce = %v, %v, want a new blog", prior, err)
+	}
+	_, prior, err = m.CreateOnce(ctx, &blogItem{Title: "Hello world"}, key)
+	if err != nil || prior == nil || prior.Pending {
+		t.Fatalf("replay = %+v, %v, want the settled key", prior, err)
+	}
+	if !proto.Equal(dataToBlogPb(prior.Blog), dataToBlogPb(created)) {
+		t.Errorf("replayed %+v, want %+v", prior.Blog, created)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"io"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) ImportBlogs(stream blogpb.BlogService_ImportBlogsServer) error {
+	fmt.Println("Import blogs request")
+
+	ctx := stream.Context()
+	// authors checked so far, nil for the unknown ones
+	authors := make(map[string]error)
+	scheduled := false
+	res := &blogpb.ImportBlogsResponse{}
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &blogpb.ImportBlogsResponse_Result{Index: index}
+		created, err := s.importBlog(ctx, req.GetBlog(), authors)
+		if err != nil {
+			result.Error = status.Convert(err).Message()
+			res.Failed++
+		} else {
+			result.BlogId = created.ID.Hex()
+			res.Imported++
+			scheduled = scheduled || created.Status == statusScheduled
+		}
+		res.Results = append(res.Results, result)
+	}
+
+	if scheduled {
+		wakeScheduler(ctx)
+	}
+	fmt.Printf("Imported %v blogs, %v failed\n", res.Imported, res.Failed)
+	return stream.SendAndClose(res)
+}
+
+// importBlog creates one imported blog. authors caches the result of
+// checking each author, so a large import does not look up the same
+// author for every blog.
+func (s *server) importBlog(ctx context.Context, blog *blogpb.Blog, authors map[string]error) (*blogItem, error) {
+	if err := validateImportedBlog(blog); err != nil {
+		return nil, err
+	}
+
+	authorErr, ok := authors[blog.GetAuthorId()]
+	if !ok {
+		_, authorErr = checkAuthor(ctx, s.store, blog.GetAuthorId())
+		authors[blog.GetAuthorId()] = authorErr
+	}
+	if authorErr != nil {
+		return nil, authorErr
+	}
+
+	st, publishAt, err := importedStatus(blog)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.store.Create(ctx, &blogItem{
+		AuthorID:  blog.GetAuthorId(),
+		Title:     blog.GetTitle(),
+		Content:   blog.GetContent(),
+		Tags:      normalizeTags(blog.GetTags()),
+		Category:  blog.GetCategory(),
+		Status:    st,
+		PublishAt: publishAt,
+	})
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+	return created, nil
+}
+
+// importedStatus is initialStatus for blogs that existed somewhere else
+// first: they keep the time they were published, may come in archived,
+// and a scheduled one whose time has passed is published by the scheduler
+// right away
+func importedStatus(blog *blogpb.Blog) (string, *time.Time, error) {
+	var at *time.Time
+	if blog.GetPublishAt() != nil {
+		t := blog.GetPublishAt().AsTime().UTC()
+		at = &t
+	}
+
+	switch blog.GetStatus() {
+	case blogpb.Blog_DRAFT:
+		return statusDraft, nil, nil
+	case blogpb.Blog_PUBLISHED:
+		if at == nil {
+			now := time.Now().UTC()
+			at = &now
+		}
+		return statusPublished, at, nil
+	case blogpb.Blog_SCHEDULED:
+		if at == nil {
+			return "", nil, fieldError("blog.publish_at", "is required for a scheduled blog")
+		}
+		return statusScheduled, at, nil
+	case blogpb.Blog_ARCHIVED:
+		return statusArchived, at, nil
+	default:
+		return "", nil, fieldError("blog.status", "is not a known status: %v", blog.GetStatus())
+	}
+}
+
+func (s *server) ExportBlogs(req *blogpb.ExportBlogsRequest, stream blogpb.BlogService_ExportBlogsServer) error {
+	fmt.Println("Export blogs request")
+
+	if err := validateExportBlogs(req); err != nil {
+		return err
+	}
+
+	opts := listOptions{}
+	if len(req.GetStatuses()) > 0 {
+		opts.Statuses = listStatuses(req.GetStatuses())
+	}
+	if err := checkStatuses(stream.Context(), s.adminToken, opts.Statuses); err != nil {
+		return err
+	}
+
+	sendErr := error(nil)
+	err := s.store.List(stream.Context(), opts, func(data *blogItem) error {
+		sendErr = stream.Send(&blogpb.ExportBlogsResponse{
+			Blog: dataToBlogPb(data),
+		})
+		return sendErr
+	})
+	if err != nil {
+		if err == sendErr {
+			return err
+		}
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error: %v", err),
+		)
+	}
+	return nil
+}
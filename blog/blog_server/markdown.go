@@ -0,0 +1,961 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wordsPerMinute is the reading speed reading times are estimated with
+const wordsPerMinute = 200
+
+// maxNesting is how deeply block quotes, lists, links and emphasis may
+// nest. Deeper markup is rendered as text, so hostile input cannot make
+// the renderer go over the same text once per level.
+const maxNesting = 32
+
+// renderedBlog is blog content converted from Markdown to HTML
+type renderedBlog struct {
+	HTML           string
+	TOC            []tocEntry
+	Words          int
+	ReadingMinutes int
+}
+
+// tocEntry is a heading of a rendered blog
+type tocEntry struct {
+	Level  int
+	Title  string
+	Anchor string
+}
+
+// renderMarkdown converts src to sanitized HTML. It covers the commonly
+// used part of Markdown: ATX and setext headings, paragraphs, emphasis,
+// strikethrough, code spans and blocks, block quotes, nested lists, rules,
+// links, images and autolinks. Raw HTML is passed on to sanitizeHTML
+// rather than escaped, so allowed tags keep working.
+func renderMarkdown(src string) *renderedBlog {
+	src = strings.Replace(src, "\r\n", "\n", -1)
+	src = strings.Replace(src, "\t", "    ", -1)
+
+	r := &mdRenderer{anchors: make(map[string]bool), suffixes: make(map[string]int)}
+	r.blocks(splitLines(src))
+
+	n := len(strings.Fields(r.text.String()))
+	minutes := (n + wordsPerMinute - 1) / wordsPerMinute
+	if minutes < 1 {
+		minutes = 1
+	}
+	return &renderedBlog{
+		HTML:           sanitizeHTML(r.out.String()),
+		TOC:            r.toc,
+		Words:          n,
+		ReadingMinutes: minutes,
+	}
+}
+
+type mdRenderer struct {
+	out  strings.Builder
+	text strings.Builder // the plain text, for counting words
+	toc  []tocEntry
+	// anchors holds the heading ids in use so repeated headings get
+	// unique ones, and suffixes the last number added to each base anchor
+	anchors  map[string]bool
+	suffixes map[string]int
+	// depth is how deeply the blocks being rendered are nested
+	depth int
+	// tight is set while rendering the items of a list without blank
+	// lines between them, whose paragraphs are not wrapped in <p>
+	tight bool
+}
+
+// blocks renders a sequence of block level elements
+func (r *mdRenderer) blocks(lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		indent, rest := leadingSpaces(line)
+		switch {
+		case rest == "":
+			i++
+		case indent >= 4:
+			i = r.indentedCode(lines, i)
+		case fenceOf(rest) != "":
+			i = r.fencedCode(lines, i)
+		case isRule(rest):
+			r.out.WriteString("<hr>\n")
+			i++
+		case atxLevel(rest) > 0:
+			level := atxLevel(rest)
+			r.heading(level, atxText(rest, level))
+			i++
+		case rest[0] == '>' && r.depth < maxNesting:
+			i = r.blockquote(lines, i)
+		case listMarker(rest) != nil && r.depth < maxNesting:
+			i = r.list(lines, i)
+		default:
+			i = r.paragraph(lines, i)
+		}
+	}
+}
+
+// leadingSpaces returns the indentation of line and the rest of it
+func leadingSpaces(line string) (int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	return len(line) - len(trimmed), strings.TrimRight(trimmed, " ")
+}
+
+// startsBlock reports whether a line would interrupt a paragraph
+func startsBlock(line string) bool {
+	indent, rest := leadingSpaces(line)
+	if rest == "" || indent >= 4 {
+		return rest == ""
+	}
+	return fenceOf(rest) != "" || isRule(rest) || atxLevel(rest) > 0 || rest[0] == '>' || listMarker(rest) != nil
+}
+
+func (r *mdRenderer) paragraph(lines []string, i int) int {
+	start := i
+	for ; i < len(lines); i++ {
+		if i > start {
+			_, rest := leadingSpaces(lines[i])
+			if level := setextLevel(rest); level > 0 {
+				r.heading(level, joinLines(lines[start:i]))
+				return i + 1
+			}
+			if startsBlock(lines[i]) {
+				break
+			}
+		}
+	}
+
+	htm, text := r.inline(joinLines(lines[start:i]))
+	if r.tight {
+		r.out.WriteString(htm)
+		r.out.WriteByte('\n')
+	} else {
+		fmt.Fprintf(&r.out, "<p>%v</p>\n", htm)
+	}
+	r.text.WriteString(text)
+	r.text.WriteByte('\n')
+	return i
+}
+
+// joinLines joins paragraph lines, keeping the trailing spaces of a hard
+// line break
+func joinLines(lines []string) string {
+	parts := make([]string, len(lines))
+	for i, l := range lines {
+		parts[i] = strings.TrimLeft(l, " ")
+	}
+	return strings.TrimRight(strings.Join(parts, "\n"), " ")
+}
+
+func (r *mdRenderer) heading(level int, src string) {
+	htm, text := r.inline(src)
+	if strings.Contains(htm, "<") {
+		// the table of contents shows the heading as it ends up on the
+		// page, without the raw HTML sanitizeHTML drops
+		text = strings.TrimSpace(htmlText(sanitizeHTML(htm)))
+	}
+	base := slugify(text)
+	anchor := base
+	if r.anchors[anchor] {
+		// carry on from the last number given to base, so n repeats of
+		// a heading take n steps rather than n*n
+		n := r.suffixes[base]
+		for {
+			n++
+			anchor = base + "-" + strconv.Itoa(n)
+			if !r.anchors[anchor] {
+				break
+			}
+		}
+		r.suffixes[base] = n
+	}
+	r.anchors[anchor] = true
+
+	r.toc = append(r.toc, tocEntry{Level: level, Title: text, Anchor: anchor})
+	fmt.Fprintf(&r.out, "<h%v id=\"%v\">%v</h%v>\n", level, anchor, htm, level)
+	r.text.WriteString(text)
+	r.text.WriteByte('\n')
+}
+
+// atxLevel returns the level of a "# heading" line, or 0 if it is not one
+func atxLevel(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(line) && line[n] != ' ') {
+		return 0
+	}
+	return n
+}
+
+// atxText strips the opening and any closing run of #s off a heading
+func atxText(line string, level int) string {
+	text := strings.TrimSpace(line[level:])
+	closing := strings.TrimRight(text, "#")
+	if closing == "" || strings.HasSuffix(closing, " ") {
+		text = strings.TrimSpace(closing)
+	}
+	return text
+}
+
+// setextLevel returns 1 or 2 for a line underlining a heading with = or -
+func setextLevel(line string) int {
+	if line == "" {
+		return 0
+	}
+	if strings.Trim(line, "=") == "" {
+		return 1
+	}
+	if strings.Trim(line, "-") == "" {
+		return 2
+	}
+	return 0
+}
+
+// isRule reports whether line is a thematic break such as --- or * * *
+func isRule(line string) bool {
+	c := line[0]
+	if c != '-' && c != '*' && c != '_' {
+		return false
+	}
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case c:
+			n++
+		case ' ':
+		default:
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// fenceOf returns the ``` or ~~~ run opening a fenced code block
+func fenceOf(line string) string {
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return ""
+	}
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	if line[0] == '`' && strings.Contains(line[n:], "`") {
+		return ""
+	}
+	return line[:n]
+}
+
+func (r *mdRenderer) fencedCode(lines []string, i int) int {
+	indent, rest := leadingSpaces(lines[i])
+	fence := fenceOf(rest)
+	lang := ""
+	if fields := strings.Fields(rest[len(fence):]); len(fields) > 0 {
+		lang = fields[0]
+	}
+
+	var code []string
+	for i++; i < len(lines); i++ {
+		_, closing := leadingSpaces(lines[i])
+		if strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+			i++
+			break
+		}
+		// the content loses as much indentation as the fence had
+		line := lines[i]
+		for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+	r.code(lang, code)
+	return i
+}
+
+func (r *mdRenderer) indentedCode(lines []string, i int) int {
+	var code []string
+	for ; i < len(lines); i++ {
+		indent, rest := leadingSpaces(lines[i])
+		if rest != "" && indent < 4 {
+			break
+		}
+		if len(lines[i]) >= 4 {
+			code = append(code, lines[i][4:])
+		} else {
+			code = append(code, "")
+		}
+	}
+	// blank lines after the block belong to whatever follows
+	for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+		code = code[:len(code)-1]
+	}
+	r.code("", code)
+	return i
+}
+
+func (r *mdRenderer) code(lang string, code []string) {
+	r.out.WriteString("<pre><code")
+	if lang = languageName(lang); lang != "" {
+		fmt.Fprintf(&r.out, " class=\"language-%v\"", lang)
+	}
+	r.out.WriteString(">")
+	for _, line := range code {
+		r.out.WriteString(html.EscapeString(line))
+		r.out.WriteByte('\n')
+		r.text.WriteString(line)
+		r.text.WriteByte('\n')
+	}
+	r.out.WriteString("</code></pre>\n")
+}
+
+// languageName returns the language of a code block, limited to the
+// characters that are safe in a class name
+func languageName(lang string) string {
+	for _, c := range lang {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("+-_#.", c) {
+			return ""
+		}
+	}
+	return strings.ToLower(lang)
+}
+
+func (r *mdRenderer) blockquote(lines []string, i int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		indent, rest := leadingSpaces(lines[i])
+		if indent >= 4 || !strings.HasPrefix(rest, ">") {
+			break
+		}
+		rest = strings.TrimPrefix(rest[1:], " ")
+		inner = append(inner, rest)
+	}
+
+	tight := r.tight
+	r.tight = false
+	r.depth++
+	r.out.WriteString("<blockquote>\n")
+	r.blocks(inner)
+	r.out.WriteString("</blockquote>\n")
+	r.depth--
+	r.tight = tight
+	return i
+}
+
+// marker is the bullet or number that starts a list item
+type marker struct {
+	ordered bool
+	start   int
+	delim   byte // the bullet, or the . or ) after the number
+	width   int  // up to where the item's content starts
+}
+
+// listMarker parses the marker at the start of line, or returns nil
+func listMarker(line string) *marker {
+	if len(line) == 0 {
+		return nil
+	}
+	if c := line[0]; c == '-' || c == '*' || c == '+' {
+		if len(line) > 1 && line[1] != ' ' {
+			return nil
+		}
+		return &marker{delim: c, width: markerWidth(line, 1)}
+	}
+
+	n := 0
+	for n < len(line) && n < 9 && line[n] >= '0' && line[n] <= '9' {
+		n++
+	}
+	if n == 0 || n >= len(line) || (line[n] != '.' && line[n] != ')') {
+		return nil
+	}
+	if n+1 < len(line) && line[n+1] != ' ' {
+		return nil
+	}
+	start, _ := strconv.Atoi(line[:n])
+	return &marker{ordered: true, start: start, delim: line[n], width: markerWidth(line, n+1)}
+}
+
+// markerWidth adds the spaces after a marker of length n to its width. A
+// run of five or more spaces starts indented code, which only gets one.
+func markerWidth(line string, n int) int {
+	spaces := 0
+	for n+spaces < len(line) && line[n+spaces] == ' ' {
+		spaces++
+	}
+	if spaces == 0 || spaces > 4 || n+spaces == len(line) {
+		spaces = 1
+	}
+	return n + spaces
+}
+
+func (r *mdRenderer) list(lines []string, i int) int {
+	indent, rest := leadingSpaces(lines[i])
+	first := listMarker(rest)
+
+	var items [][]string
+	var item []string
+	tight := true
+	blank := false
+	for ; i < len(lines); i++ {
+		lineIndent, rest := leadingSpaces(lines[i])
+		if rest == "" {
+			blank = true
+			item = append(item, "")
+			continue
+		}
+
+		m := listMarker(rest)
+		if lineIndent < indent+first.width && m != nil && !isRule(rest) {
+			if m.ordered != first.ordered || m.delim != first.delim {
+				break
+			}
+			if item != nil {
+				items = append(items, item)
+			}
+			if blank {
+				tight = false
+			}
+			blank = false
+			content := ""
+			if m.width < len(rest) {
+				content = rest[m.width:]
+			}
+			item = []string{content}
+			continue
+		}
+
+		switch {
+		case lineIndent >= indent+first.width:
+			if blank && !lastIsBlankOnly(item) {
+				tight = false
+			}
+			item = append(item, lines[i][indent+first.width:])
+		case !blank && !startsBlock(lines[i]):
+			// a lazy continuation of the item's last paragraph
+			item = append(item, rest)
+		default:
+			items = append(items, item)
+			r.renderList(first, items, tight)
+			return i
+		}
+		blank = false
+	}
+	items = append(items, item)
+	r.renderList(first, items, tight)
+	return i
+}
+
+// lastIsBlankOnly reports whether item has nothing but blank lines, as
+// it does right after an empty marker line
+func lastIsBlankOnly(item []string) bool {
+	for _, l := range item {
+		if strings.TrimSpace(l) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *mdRenderer) renderList(m *marker, items [][]string, tight bool) {
+	tag := "ul"
+	if m.ordered {
+		tag = "ol"
+	}
+	if m.ordered && m.start != 1 {
+		fmt.Fprintf(&r.out, "<ol start=\"%v\">\n", m.start)
+	} else {
+		fmt.Fprintf(&r.out, "<%v>\n", tag)
+	}
+
+	outer := r.tight
+	r.tight = tight
+	r.depth++
+	for _, item := range items {
+		r.out.WriteString("<li>")
+		r.blocks(item)
+		r.out.WriteString("</li>\n")
+	}
+	r.depth--
+	r.tight = outer
+	fmt.Fprintf(&r.out, "</%v>\n", tag)
+}
+
+// inline renders the inline elements of src and returns the HTML along
+// with the plain text of it
+func (r *mdRenderer) inline(src string) (string, string) {
+	var out, text strings.Builder
+	in := &inliner{src: src, depth: r.depth, spans: &spanIndex{src: src}}
+	in.render(&out, &text)
+	return out.String(), text.String()
+}
+
+// inliner renders the inline elements of src, which is the text of a
+// paragraph or heading or a part of it inside a link or emphasis. Where
+// scans for closing delimiters went is remembered, so a run of unclosed
+// delimiters costs one pass over src rather than one each.
+type inliner struct {
+	src   string
+	depth int // how deeply src is nested in blocks, links and emphasis
+	// spans indexes the whole text, which starts offset bytes before src
+	spans  *spanIndex
+	offset int
+	// scanned has a bit for each emphasis delimiter set at the indexes a
+	// scan for its closer went past
+	scanned []uint8
+	// gt is the index of the first > at or after gtFrom, -1 for none,
+	// once gtKnown is set
+	gtFrom, gt int
+	gtKnown    bool
+}
+
+// nested returns an inliner for src[from:to]
+func (in *inliner) nested(from, to int) *inliner {
+	return &inliner{
+		src:    in.src[from:to],
+		depth:  in.depth + 1,
+		spans:  in.spans,
+		offset: in.offset + from,
+	}
+}
+
+func (in *inliner) render(out, text *strings.Builder) {
+	src := in.src
+	if in.depth >= maxNesting {
+		writeText(out, text, src)
+		return
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\\' && i+1 < len(src) && isASCIIPunct(src[i+1]):
+			writeText(out, text, src[i+1:i+2])
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			out.WriteString("<br>\n")
+			text.WriteByte('\n')
+			i += 2
+			continue
+		case c == '\n':
+			if strings.HasSuffix(src[:i], "  ") {
+				out.WriteString("<br>")
+			}
+			out.WriteByte('\n')
+			text.WriteByte('\n')
+			i++
+			continue
+		case c == '`':
+			if n := in.codeSpan(out, text, i); n > 0 {
+				i += n
+				continue
+			}
+		case c == '!' && strings.HasPrefix(src[i:], "!["):
+			if n := in.link(out, text, i, true); n > 0 {
+				i += n
+				continue
+			}
+		case c == '[':
+			if n := in.link(out, text, i, false); n > 0 {
+				i += n
+				continue
+			}
+		case c == '<':
+			if n := in.angle(out, text, i); n > 0 {
+				i += n
+				continue
+			}
+		case c == '&':
+			if n := entity(src[i:]); n > 0 {
+				out.WriteString(src[i : i+n])
+				text.WriteString(html.UnescapeString(src[i : i+n]))
+				i += n
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if n := in.emphasis(out, text, i); n > 0 {
+				i += n
+				continue
+			}
+		}
+
+		// plain text up to the next character that may start markup
+		j := i + 1
+		for j < len(src) && !strings.ContainsRune("\\\n`![<&*_~", rune(src[j])) {
+			j++
+		}
+		line := src[i:j]
+		if j < len(src) && src[j] == '\n' {
+			line = strings.TrimRight(line, " ")
+		}
+		writeText(out, text, line)
+		i = j
+	}
+}
+
+func writeText(out, text *strings.Builder, s string) {
+	out.WriteString(html.EscapeString(s))
+	text.WriteString(s)
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < 0x80 && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+// codeSpan renders a `code` span starting at src[i] and returns its
+// length, or 0 if the backticks are not closed
+func (in *inliner) codeSpan(out, text *strings.Builder, i int) int {
+	src := in.src
+	n := 0
+	for i+n < len(src) && src[i+n] == '`' {
+		n++
+	}
+	// only a run of just as many backticks closes the span
+	k := in.spans.backticks(n, in.offset+i+n) - in.offset
+	if k < i+n || k+n > len(src) {
+		return 0
+	}
+	code := strings.Replace(src[i+n:k], "\n", " ", -1)
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	out.WriteString("<code>")
+	writeText(out, text, code)
+	out.WriteString("</code>")
+	return k + n - i
+}
+
+// link renders a [text](url "title") link, or an image when image is set,
+// starting at src[i] and returns its length, or 0 if there is none
+func (in *inliner) link(out, text *strings.Builder, i int, image bool) int {
+	src := in.src
+	open := i + 1
+	if image {
+		open = i + 2
+	}
+	closeAt := in.closer(open - 1)
+	if closeAt < 0 || closeAt+1 >= len(src) || src[closeAt+1] != '(' {
+		return 0
+	}
+	// the destination may hold balanced parentheses of its own
+	end := in.closer(closeAt + 1)
+	if end < 0 {
+		return 0
+	}
+
+	dest, title := linkTarget(src[closeAt+2 : end])
+	var label, labelText strings.Builder
+	in.nested(open, closeAt).render(&label, &labelText)
+
+	if image {
+		fmt.Fprintf(out, "<img src=\"%v\" alt=\"%v\"", html.EscapeString(dest), html.EscapeString(labelText.String()))
+	} else {
+		fmt.Fprintf(out, "<a href=\"%v\"", html.EscapeString(dest))
+	}
+	if title != "" {
+		fmt.Fprintf(out, " title=\"%v\"", html.EscapeString(title))
+	}
+	if image {
+		out.WriteString(">")
+	} else {
+		fmt.Fprintf(out, ">%v</a>", label.String())
+	}
+	text.WriteString(labelText.String())
+	return end + 1 - i
+}
+
+// closer returns the index of the ] or ) closing the [ or ( at src[i], or
+// -1 if it is not closed within src
+func (in *inliner) closer(i int) int {
+	c := in.spans.closer(in.offset + i)
+	if c < 0 || c >= in.offset+len(in.src) {
+		return -1
+	}
+	return c - in.offset
+}
+
+// spanIndex finds where the spans of a text end, going over the text once
+// for each kind of span the first time it is asked about one
+type spanIndex struct {
+	src     string
+	closers []int32       // closers[i] closes src[i], -1 if nothing does
+	runs    map[int][]int // the starts of the runs of n backticks, by n
+}
+
+// closer returns the index of the ] or ) closing the [ or ( at src[i], or
+// -1. Both nest, and the ones escaped with a backslash do not count.
+func (x *spanIndex) closer(i int) int {
+	if x.closers == nil {
+		x.closers = make([]int32, len(x.src))
+		var brackets, parens []int
+		for j := 0; j < len(x.src); j++ {
+			x.closers[j] = -1
+			switch x.src[j] {
+			case '\\':
+				j++
+				if j < len(x.src) {
+					x.closers[j] = -1
+				}
+			case '[':
+				brackets = append(brackets, j)
+			case '(':
+				parens = append(parens, j)
+			case ']':
+				if n := len(brackets); n > 0 {
+					x.closers[brackets[n-1]] = int32(j)
+					brackets = brackets[:n-1]
+				}
+			case ')':
+				if n := len(parens); n > 0 {
+					x.closers[parens[n-1]] = int32(j)
+					parens = parens[:n-1]
+				}
+			}
+		}
+	}
+	return int(x.closers[i])
+}
+
+// backticks returns the start of the first run of exactly n backticks at
+// or after src[from], or -1
+func (x *spanIndex) backticks(n, from int) int {
+	if x.runs == nil {
+		x.runs = make(map[int][]int)
+		for j := 0; j < len(x.src); {
+			if x.src[j] != '`' {
+				j++
+				continue
+			}
+			start := j
+			for j < len(x.src) && x.src[j] == '`' {
+				j++
+			}
+			x.runs[j-start] = append(x.runs[j-start], start)
+		}
+	}
+	starts := x.runs[n]
+	if k := sort.SearchInts(starts, from); k < len(starts) {
+		return starts[k]
+	}
+	return -1
+}
+
+// linkTarget splits the inside of a link's parentheses into the
+// destination and an optional quoted title
+func linkTarget(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	dest, title := s, ""
+	if i := strings.IndexAny(s, " \n"); i >= 0 {
+		dest, title = s[:i], strings.TrimSpace(s[i:])
+		if len(title) >= 2 && (title[0] == '"' || title[0] == '\'') && title[len(title)-1] == title[0] {
+			title = title[1 : len(title)-1]
+		} else {
+			title = ""
+		}
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return html.UnescapeString(dest), html.UnescapeString(title)
+}
+
+// angle handles a < at src[i]: an <https://...> autolink or a raw HTML
+// tag, which is left for sanitizeHTML to judge. It returns the length
+// consumed, or 0 if the < is just text.
+func (in *inliner) angle(out, text *strings.Builder, i int) int {
+	end := in.indexGT(i)
+	if end < 0 {
+		return 0
+	}
+	src := in.src[i:]
+	end -= i
+	inner := src[1:end]
+	if strings.ContainsAny(inner, " <\n") {
+		if isTag(src[:end+1]) {
+			out.WriteString(src[:end+1])
+			return end + 1
+		}
+		return 0
+	}
+
+	lower := strings.ToLower(inner)
+	switch {
+	case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"):
+		fmt.Fprintf(out, "<a href=\"%v\">", html.EscapeString(inner))
+	case strings.Contains(inner, "@") && !strings.Contains(inner, ":"):
+		fmt.Fprintf(out, "<a href=\"mailto:%v\">", html.EscapeString(inner))
+	case isTag(src[:end+1]):
+		out.WriteString(src[:end+1])
+		return end + 1
+	default:
+		return 0
+	}
+	writeText(out, text, inner)
+	out.WriteString("</a>")
+	return end + 1
+}
+
+// indexGT returns the index of the first > at or after src[i], or -1,
+// reusing the last answer while it still holds
+func (in *inliner) indexGT(i int) int {
+	if !in.gtKnown || i < in.gtFrom || in.gt >= 0 && i > in.gt {
+		in.gtFrom, in.gt, in.gtKnown = i, strings.IndexByte(in.src[i:], '>'), true
+		if in.gt >= 0 {
+			in.gt += i
+		}
+	}
+	return in.gt
+}
+
+// isTag reports whether s looks like an HTML open, close or comment tag
+func isTag(s string) bool {
+	if len(s) < 3 {
+		return false
+	}
+	c := s[1]
+	if c == '/' || c == '!' {
+		if len(s) < 4 {
+			return false
+		}
+		c = s[2]
+	}
+	return c < 0x80 && unicode.IsLetter(rune(c)) || strings.HasPrefix(s, "<!--")
+}
+
+// entity returns the length of an HTML entity at the start of s, or 0
+func entity(s string) int {
+	// no entity name is longer than 32 bytes
+	if len(s) > 33 {
+		s = s[:33]
+	}
+	end := strings.IndexByte(s, ';')
+	if end < 2 {
+		return 0
+	}
+	name := s[1:end]
+	for i, c := range name {
+		if !(c < 0x80 && (unicode.IsLetter(c) || unicode.IsDigit(c)) || (i == 0 && c == '#')) {
+			return 0
+		}
+	}
+	if html.UnescapeString(s[:end+1]) == s[:end+1] {
+		// not an entity html knows
+		return 0
+	}
+	return end + 1
+}
+
+// emphasis renders *em*, **strong** or ~~del~~ starting at src[i] and
+// returns its length, or 0 if the delimiter is not closed. An _ inside a
+// word, as in snake_case, is left alone.
+func (in *inliner) emphasis(out, text *strings.Builder, i int) int {
+	src := in.src
+	c := src[i]
+	n := 0
+	for i+n < len(src) && src[i+n] == c {
+		n++
+	}
+	if c == '~' && n != 2 {
+		return 0
+	}
+	if c == '_' && i > 0 && isWordByte(src[i-1]) {
+		return 0
+	}
+	if n > 2 {
+		n = 2
+	}
+	if i+n >= len(src) || src[i+n] == ' ' || src[i+n] == '\n' {
+		return 0
+	}
+
+	delim := src[i : i+n]
+	if in.scanned == nil {
+		in.scanned = make([]uint8, len(src))
+	}
+	bit := emphasisBits[delim]
+	for j := i + n + 1; j+n <= len(src); j++ {
+		// where a scan goes from j on depends on nothing but j, and a scan
+		// that found a closer is never followed by one starting before
+		// it, so a scan that comes to where another went finds nothing
+		if in.scanned[j]&bit != 0 {
+			return 0
+		}
+		in.scanned[j] |= bit
+		if src[j] == '\\' {
+			j++
+			continue
+		}
+		if src[j] == '`' {
+			// delimiters inside a code span do not count
+			var discard strings.Builder
+			if k := in.codeSpan(&discard, &discard, j); k > 0 {
+				j += k - 1
+				continue
+			}
+		}
+		if src[j:j+n] != delim || src[j-1] == ' ' || src[j-1] == '\n' || (n == 1 && src[j-1] == c) {
+			continue
+		}
+		after := j + n
+		if after < len(src) && src[after] == c {
+			if n == 1 {
+				// skip the closer of a nested strong run
+				for after < len(src) && src[after] == c {
+					after++
+				}
+				j = after - 1
+			}
+			continue
+		}
+		if c == '_' && after < len(src) && isWordByte(src[after]) {
+			continue
+		}
+
+		tag := "em"
+		switch {
+		case c == '~':
+			tag = "del"
+		case n == 2:
+			tag = "strong"
+		}
+		fmt.Fprintf(out, "<%v>", tag)
+		in.nested(i+n, j).render(out, text)
+		fmt.Fprintf(out, "</%v>", tag)
+		return after - i
+	}
+	return 0
+}
+
+// emphasisBits gives each emphasis delimiter a bit in inliner.scanned
+var emphasisBits = map[string]uint8{"*": 1, "**": 2, "_": 4, "__": 8, "~~": 16}
+
+func isWordByte(c byte) bool {
+	return c >= 0x80 || c < 0x80 && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)))
+}
+
+// slugify turns a heading into an anchor: lowercase letters and digits,
+// with dashes between words
+func slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(c)
+		case c == ' ' || c == '-' || c == '_':
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
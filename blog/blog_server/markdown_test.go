@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		html string
+	}{
+		{"emphasis", "*em*, **strong**, ~~del~~ and `code`", "<p><em>em</em>, <strong>strong</strong>, <del>del</del> and <code>code</code></p>\n"},
+		{"intraword underscores", "snake_case_name and _em_", "<p>snake_case_name and <em>em</em></p>\n"},
+		{"unclosed delimiters", "unclosed *em and [bracket and `tick", "<p>unclosed *em and [bracket and `tick</p>\n"},
+		{"link", "[link](http://x.y \"T\")", "<p><a href=\"http://x.y\" title=\"T\" rel=\"nofollow noopener\">link</a></p>\n"},
+		{"script link", "[a](javascript:alert(1))", "<p><a rel=\"nofollow noopener\">a</a></p>\n"},
+		{"autolinks", "<https://a.b> <me@x.y>", "<p><a href=\"https://a.b\" rel=\"nofollow noopener\">https://a.b</a> <a href=\"mailto:me@x.y\" rel=\"nofollow noopener\">me@x.y</a></p>\n"},
+		{"raw html", "<img src=x onerror=alert(1)>", "<p><img src=\"x\"></p>\n"},
+		{"quote", "> quote\n> more", "<blockquote>\n<p>quote\nmore</p>\n</blockquote>\n"},
+		{"code block", "```go\na < b\n```", "<pre><code class=\"language-go\">a &lt; b\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.src).HTML; got != tt.html {
+				t.Errorf("renderMarkdown(%q) = %q, want %q", tt.src, got, tt.html)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownTOC(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		toc  []tocEntry
+	}{
+		{"levels", "# One\n## Two", []tocEntry{{1, "One", "one"}, {2, "Two", "two"}}},
+		{"repeats", "# A\n# A\n# A", []tocEntry{{1, "A", "a"}, {1, "A", "a-1"}, {1, "A", "a-2"}}},
+		{"taken suffix", "# A\n# A-1\n# A\n# A", []tocEntry{{1, "A", "a"}, {1, "A-1", "a-1"}, {1, "A", "a-2"}, {1, "A", "a-3"}}},
+		{"script", "# Hi <script>alert(1)</script>", []tocEntry{{1, "Hi", "hi"}}},
+		{"inline html", "# A &amp; <b>B</b>", []tocEntry{{1, "A & B", "a-b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.src).TOC; !reflect.DeepEqual(got, tt.toc) {
+				t.Errorf("renderMarkdown(%q).TOC = %+v, want %+v", tt.src, got, tt.toc)
+			}
+		})
+	}
+}
+
+// TestRenderMarkdownHostile checks that input crafted to make the renderer
+// rescan it takes about as long as the same amount of plain text
+func TestRenderMarkdownHostile(t *testing.T) {
+	// rendering 4 times the input takes about 4 times as long when it is
+	// linear and 16 times as long when it is quadratic; the ratio, unlike
+	// a deadline, does not depend on how fast the machine is
+	const size, maxRatio = 1 << 15, 10
+	tests := []struct {
+		name string
+		unit string
+	}{
+		{"headings", "# a\n"},
+		{"emphasis", "*a "},
+		{"strong", "**a "},
+		{"underscores", "_a "},
+		{"strikethrough", "~~a "},
+		{"brackets", "[a "},
+		{"links", "[a]("},
+		{"backticks", "`a ``b "},
+		{"autolinks", "<a "},
+		{"quotes", ">"},
+		{"lists", "- "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			small := renderTime(strings.Repeat(tt.unit, size/len(tt.unit)))
+			large := renderTime(strings.Repeat(tt.unit, 4*size/len(tt.unit)))
+			// below a millisecond the noise outweighs the rendering
+			base := small
+			if base < time.Millisecond {
+				base = time.Millisecond
+			}
+			if ratio := float64(large) / float64(base); ratio > maxRatio {
+				t.Errorf("rendering 4 times as much %q took %.1f times as long (%v, then %v)", tt.unit, ratio, small, large)
+			}
+		})
+	}
+}
+
+// renderTime returns the fastest of a few renderings of src, which leaves
+// out most of the noise of the scheduler and the garbage collector
+func renderTime(src string) time.Duration {
+	best := time.Duration(math.MaxInt64)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		renderMarkdown(src)
+		if d := time.Since(start); d < best {
+			best = d
+		}
+	}
+	return best
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migration is one step in bringing myblogdb up to date with what the
+// server expects
+type migration struct {
+	Version     int
+	Description string
+	// Count, when set, tells a dry run how many documents the migration
+	// would change
+	Count func(ctx context.Context, m *mongoStore) (int64, error)
+	Apply func(ctx context.Context, m *mongoStore) error
+}
+
+// migrationRecord marks a migration as applied in myblogdb.schema_migration
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// migrations run in order, each once per database. Two servers starting
+// together may both run one before either records it, so every migration
+// must be safe to run again. Once a migration has shipped it is never
+// changed; fix it with a new one instead.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "index blogs by author and by creation time",
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			_, err := m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.M{"author_id": 1}},
+				{Keys: bson.M{"created_at": 1}},
+			})
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "unique index on the slugs of blogs",
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			// no two blogs share a slug, current or old; blogs from before
+			// there were slugs have none and are left out
+			_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.M{"slugs": 1},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"slugs": bson.M{"$exists": true}}),
+			})
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "expire idempotency keys and view records",
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			for _, c := range []*mongo.Collection{m.keys, m.views} {
+				_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.M{"expires_at": 1},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				})
+				if err != nil {
+					return fmt.Errorf("cannot index %v: %v", c.Name(), err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: "backfill created_at from the id of blogs written before it",
+		Count:       countBlogs(bson.M{"created_at": bson.M{"$exists": false}}),
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			filter := bson.M{"created_at": bson.M{"$exists": false}}
+			return m.eachBlog(ctx, filter, func(item *blogItem) error {
+				_, err := m.collection.UpdateOne(ctx,
+					bson.M{"_id": item.ID, "created_at": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"created_at": item.ID.Timestamp()}},
+				)
+				return err
+			})
+		},
+	},
+	{
+		Version:     5,
+		Description: "backfill the status of blogs from before drafts as published",
+		Count:       countBlogs(bson.M{"status": bson.M{"$in": bson.A{nil, ""}}}),
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			_, err := m.collection.UpdateMany(ctx,
+				bson.M{"status": bson.M{"$in": bson.A{nil, ""}}},
+				bson.M{"$set": bson.M{"status": statusPublished}},
+			)
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "give blogs from before slugs a slug",
+		Count:       countBlogs(bson.M{"slugs": bson.M{"$exists": false}}),
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			filter := bson.M{"slugs": bson.M{"$exists": false}}
+			return m.eachBlog(ctx, filter, func(item *blogItem) error {
+				for attempt := 1; ; attempt++ {
+					slug, err := m.freeSlug(ctx, titleSlug(item.Title), item.ID)
+					if err != nil {
+						return err
+					}
+					_, err = m.collection.UpdateOne(ctx,
+						bson.M{"_id": item.ID, "slugs": bson.M{"$exists": false}},
+						bson.M{"$set": bson.M{"slugs": []string{slug}}},
+					)
+					if err == nil || !isDuplicateKey(err) || attempt == slugAttempts {
+						return err
+					}
+				}
+			})
+		},
+	},
+	{
+		Version:     7,
+		Description: "index revisions, comments, reactions and views by blog",
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			indexes := []struct {
+				c      *mongo.Collection
+				models []mongo.IndexModel
+			}{
+				{m.revisions, []mongo.IndexModel{{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "version", Value: 1}}}}},
+				{m.comments, []mongo.IndexModel{{Keys: bson.M{"blog_id": 1}}, {Keys: bson.M{"parent_id": 1}}}},
+				{m.reactions, []mongo.IndexModel{{Keys: bson.M{"blog_id": 1}}}},
+				{m.views, []mongo.IndexModel{{Keys: bson.M{"blog_id": 1}}}},
+			}
+			for _, idx := range indexes {
+				if _, err := idx.c.Indexes().CreateMany(ctx, idx.models); err != nil {
+					return fmt.Errorf("cannot index %v: %v", idx.c.Name(), err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     8,
+		Description: "backfill publish_at of blogs published before drafts from created_at",
+		Count:       countBlogs(bson.M{"status": statusPublished, "publish_at": bson.M{"$exists": false}}),
+		Apply: func(ctx context.Context, m *mongoStore) error {
+			// without a publish time these blogs sort after every other
+			// one by publish time, as Mongo puts missing values first
+			filter := bson.M{"status": statusPublished, "publish_at": bson.M{"$exists": false}}
+			return m.eachBlog(ctx, filter, func(item *blogItem) error {
+				_, err := m.collection.UpdateOne(ctx,
+					bson.M{"_id": item.ID, "publish_at": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"publish_at": item.createdAt()}},
+				)
+				return err
+			})
+		},
+	},
+}
+
+// countBlogs returns a Count for a migration that changes the blogs
+// matching filter
+func countBlogs(filter bson.M) func(ctx context.Context, m *mongoStore) (int64, error) {
+	return func(ctx context.Context, m *mongoStore) (int64, error) {
+		return m.collection.CountDocuments(ctx, filter)
+	}
+}
+
+// eachBlog calls fn for every blog matching filter, trashed or not
+func (m *mongoStore) eachBlog(ctx context.Context, filter bson.M, fn func(*blogItem) error) error {
+	cur, err := m.collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		item := &blogItem{}
+		if err := cur.Decode(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// migrate applies the migrations that are not recorded as applied yet,
+// or with dryRun only prints them
+func (m *mongoStore) migrate(ctx context.Context, dryRun bool) error {
+	cur, err := m.migrations.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("cannot read the applied migrations: %v", err)
+	}
+	var records []migrationRecord
+	if err := cur.All(ctx, &records); err != nil {
+		return fmt.Errorf("cannot read the applied migrations: %v", err)
+	}
+	applied := make(map[int]bool, len(records))
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+
+	pending := 0
+	for _, mig := range migrations {
+		if applied[mig.Version] {
+			continue
+		}
+		pending++
+		if dryRun {
+			plan := ""
+			if mig.Count != nil {
+				n, err := mig.Count(ctx, m)
+				if err != nil {
+					return fmt.Errorf("cannot plan migration %v: %v", mig.Version, err)
+				}
+				plan = fmt.Sprintf(" (%v documents)", n)
+			}
+			fmt.Printf("Would apply migration %v: %v%v\n", mig.Version, mig.Description, plan)
+			continue
+		}
+
+		fmt.Printf("Applying migration %v: %v\n", mig.Version, mig.Description)
+		if err := mig.Apply(ctx, m); err != nil {
+			return fmt.Errorf("migration %v failed: %v", mig.Version, err)
+		}
+		record := migrationRecord{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now().UTC()}
+		opts := options.Replace().SetUpsert(true)
+		if _, err := m.migrations.ReplaceOne(ctx, bson.M{"_id": mig.Version}, record, opts); err != nil {
+			return fmt.Errorf("migration %v was applied but not recorded: %v", mig.Version, err)
+		}
+	}
+
+	if pending == 0 {
+		fmt.Println("Database schema is up to date")
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			t.Errorf("migration %v has version %v, want %v", i, mig.Version, i+1)
+		}
+		if mig.Description == "" || mig.Apply == nil {
+			t.Errorf("migration %v needs a description and an Apply", mig.Version)
+		}
+	}
+}
+
+// testMongoStore returns a mongoStore on collections of their own in the
+// database at $BLOG_TEST_MONGO_URI, migrated with dryRun, along with the
+// prefix of those collections. It skips the test when that is unset.
+func testMongoStore(t *testing.T, dryRun bool) (*mongoStore, string, func()) {
+	uri := os.Getenv("BLOG_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("BLOG_TEST_MONGO_URI is not set")
+	}
+	prefix := fmt.Sprintf("test_%v_", primitive.NewObjectID().Hex())
+	store, closeFn, err := newMongoStore(uri, "myblogdb_test", prefix, dryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := store.(*mongoStore)
+	return m, prefix, func() {
+		for _, c := range []string{"blog", "comment", "revision", "tag", "author", "idempotency_key", "attachment", "reaction", "view", "schema_migration"} {
+			m.collection.Database().Collection(prefix + c).Drop(context.Background())
+		}
+		closeFn()
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		dryRun  bool
+		applied int
+		indexes map[string]int // of each collection, counting _id
+	}{
+		{"dry run", true, 0, nil},
+		{"apply", false, len(migrations), map[string]int{"blog": 4, "revision": 2, "comment": 3, "reaction": 2, "view": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			m, prefix, cleanup := testMongoStore(t, tt.dryRun)
+			defer cleanup()
+
+			n, err := m.migrations.CountDocuments(ctx, bson.M{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(n) != tt.applied {
+				t.Errorf("%v migrations recorded, want %v", n, tt.applied)
+			}
+			db := m.collection.Database()
+			if tt.dryRun {
+				names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^" + prefix}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(names) > 0 {
+					t.Errorf("a dry run made collections %v", names)
+				}
+			}
+			for name, want := range tt.indexes {
+				cur, err := db.Collection(prefix + name).Indexes().List(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var indexes []bson.M
+				if err := cur.All(ctx, &indexes); err != nil {
+					t.Fatal(err)
+				}
+				if len(indexes) != want {
+					t.Errorf("%v has %v indexes, want %v", name, len(indexes), want)
+				}
+			}
+
+			// every migration is safe to run again
+			if !tt.dryRun {
+				if err := m.migrate(ctx, false); err != nil {
+					t.Errorf("migrating again failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateBackfillsPublishAt(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	m, _, cleanup := testMongoStore(t, false)
+	defer cleanup()
+
+	// a blog as written before created_at and drafts, and a newer one
+	old := primitive.NewObjectIDFromTimestamp(time.Now().Add(-time.Hour))
+	if _, err := m.collection.InsertOne(ctx, bson.M{"_id": old, "title": "old", "content": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	newer, err := m.Create(ctx, &blogItem{Title: "newer", Status: statusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.migrations.DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.migrate(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := m.Get(ctx, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.PublishAt == nil || !item.PublishAt.Equal(old.Timestamp()) {
+		t.Errorf("publish_at = %v, want %v", item.PublishAt, old.Timestamp())
+	}
+	var order []primitive.ObjectID
+	opts := listOptions{Descending: true, ByPublishTime: true, Statuses: []string{statusPublished}}
+	m.List(ctx, opts, func(item *blogItem) error {
+		order = append(order, item.ID)
+		return nil
+	})
+	if !reflect.DeepEqual(order, []primitive.ObjectID{newer.ID, old}) {
+		t.Errorf("newest first by publish time = %v, want %v", order, []primitive.ObjectID{newer.ID, old})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// blog statuses as they are stored
+const (
+	statusDraft     = "draft"
+	statusScheduled = "scheduled"
+	statusPublished = "published"
+	statusArchived  = "archived"
+)
+
+var statusToPb = map[string]blogpb.Blog_Status{
+	statusDraft:     blogpb.Blog_DRAFT,
+	statusScheduled: blogpb.Blog_SCHEDULED,
+	statusPublished: blogpb.Blog_PUBLISHED,
+	statusArchived:  blogpb.Blog_ARCHIVED,
+}
+
+var statusFromPb = map[blogpb.Blog_Status]string{
+	blogpb.Blog_DRAFT:     statusDraft,
+	blogpb.Blog_SCHEDULED: statusScheduled,
+	blogpb.Blog_PUBLISHED: statusPublished,
+	blogpb.Blog_ARCHIVED:  statusArchived,
+}
+
+// status returns the status of the blog, treating blogs from before there
+// were statuses as published
+func (item *blogItem) status() string {
+	if item.Status == "" {
+		return statusPublished
+	}
+	return item.Status
+}
+
+func (item *blogItem) published() bool {
+	return item.status() == statusPublished
+}
+
+// countedTags returns the tags item counts towards in ListTags, which are
+// none unless it is published and outside the trash
+func (item *blogItem) countedTags() []string {
+	if item.DeletedAt != nil || !item.published() {
+		return nil
+	}
+	return item.Tags
+}
+
+// initialStatus works out the status and publish time a new blog starts
+// with, a draft unless it asks to be published or scheduled
+func initialStatus(blog *blogpb.Blog) (string, *time.Time, error) {
+	now := time.Now().UTC()
+	switch blog.GetStatus() {
+	case blogpb.Blog_DRAFT:
+		return statusDraft, nil, nil
+	case blogpb.Blog_PUBLISHED:
+		return statusPublished, &now, nil
+	case blogpb.Blog_SCHEDULED:
+		if blog.GetPublishAt() == nil || !blog.GetPublishAt().AsTime().After(now) {
+			return "", nil, fieldError("blog.publish_at", "must be in the future for a scheduled blog")
+		}
+		at := blog.GetPublishAt().AsTime().UTC()
+		return statusScheduled, &at, nil
+	default:
+		return "", nil, fieldError("blog.status", "a blog cannot be created as %v", blog.GetStatus())
+	}
+}
+
+// listStatuses returns the statuses a listing asked for, only published
+// blogs if it did not ask for any known one
+func listStatuses(statuses []blogpb.Blog_Status) []string {
+	var out []string
+	for _, st := range statuses {
+		if name, ok := statusFromPb[st]; ok {
+			out = append(out, name)
+		}
+	}
+	if len(out) == 0 {
+		return []string{statusPublished}
+	}
+	return out
+}
+
+// checkUnpublished turns down a call for blogs that are not published
+// unless it carries adminToken
+func checkUnpublished(ctx context.Context, adminToken string, include bool) error {
+	if include && !hasAdminToken(ctx, adminToken) {
+		return status.Errorf(
+			codes.PermissionDenied,
+			fmt.Sprintf("Blogs that are not published need the admin token in %v metadata", adminMetadataKey),
+		)
+	}
+	return nil
+}
+
+// checkStatuses is checkUnpublished for a call listing blogs of statuses,
+// of every status if it is empty
+func checkStatuses(ctx context.Context, adminToken string, statuses []string) error {
+	unpublished := len(statuses) == 0
+	for _, st := range statuses {
+		unpublished = unpublished || st != statusPublished
+	}
+	return checkUnpublished(ctx, adminToken, unpublished)
+}
+
+func (s *server) PublishBlog(ctx context.Context, req *blogpb.PublishBlogRequest) (*blogpb.PublishBlogResponse, error) {
+	fmt.Println("Publish blog request")
+
+	if err := validateBlogID(req.GetBlogId()); err != nil {
+		return nil, err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.store.Get(ctx, oid)
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	if current.published() {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			fmt.Sprintf("Blog %v is already published", req.GetBlogId()),
+		)
+	}
+
+	now := time.Now().UTC()
+	to, at := statusPublished, now
+	if req.GetPublishAt() != nil && req.GetPublishAt().AsTime().After(now) {
+		to, at = statusScheduled, req.GetPublishAt().AsTime().UTC()
+	}
+
+	data, err := s.store.SetStatus(ctx, oid, current.status(), to, &at)
+	if err != nil {
+		return nil, statusError(err, req.GetBlogId())
+	}
+	if to == statusScheduled {
+		wakeScheduler(ctx)
+	}
+	return &blogpb.PublishBlogResponse{
+		Blog: dataToBlogPb(data),
+	}, nil
+}
+
+func (s *server) UnpublishBlog(ctx context.Context, req *blogpb.UnpublishBlogRequest) (*blogpb.UnpublishBlogResponse, error) {
+	fmt.Println("Unpublish blog request")
+
+	if err := validateBlogID(req.GetBlogId()); err != nil {
+		return nil, err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.store.Get(ctx, oid)
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	// an archived blog remembers when it was published, a draft does not
+	to, at := statusDraft, (*time.Time)(nil)
+	if req.GetArchive() {
+		to, at = statusArchived, current.PublishAt
+		if !current.published() {
+			at = nil
+		}
+	}
+	if current.status() == to {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			fmt.Sprintf("Blog %v is already %v", req.GetBlogId(), to),
+		)
+	}
+
+	data, err := s.store.SetStatus(ctx, oid, current.status(), to, at)
+	if err != nil {
+		return nil, statusError(err, req.GetBlogId())
+	}
+	return &blogpb.UnpublishBlogResponse{
+		Blog: dataToBlogPb(data),
+	}, nil
+}
+
+// statusError converts an error from BlogStore.SetStatus into a gRPC status
+func statusError(err error, id string) error {
+	if err == errStatusConflict {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			fmt.Sprintf("The status of blog %v was changed by someone else, read it again and retry", id),
+		)
+	}
+	return storeError(err, id)
+}
+
+func dataToPublishAtPb(data *blogItem) *timestamppb.Timestamp {
+	if data.PublishAt == nil {
+		return nil
+	}
+	return timestamppb.New(*data.PublishAt)
+}
+
+// scheduler publishes scheduled blogs when their time comes. It keeps no
+// state of its own: every round it asks the store for the scheduled blogs,
+// so blogs scheduled before a restart still go out, right away if their
+// time passed while the server was down.
+type scheduler struct {
+	store BlogStore
+	// maxWait bounds the time between rounds, which picks up blogs
+	// scheduled by another server sharing the store
+	maxWait time.Duration
+
+	wakeCh chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// startScheduler starts publishing the scheduled blogs in store. Call
+// Stop to end it.
+func startScheduler(store BlogStore, maxWait time.Duration) *scheduler {
+	s := &scheduler{
+		store:   store,
+		maxWait: maxWait,
+		wakeCh:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// wake makes the scheduler look at the scheduled blogs again, after one
+// was scheduled that may be due before the one it is waiting for
+func (s *scheduler) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *scheduler) run() {
+	defer close(s.done)
+	for {
+		wait := s.maxWait
+		if next, ok := s.publishDue(); ok && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		if wait <= 0 {
+			// a due blog failed to publish, retry it shortly
+			wait = time.Second
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wakeCh:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// publishDue publishes the blogs that are due and returns when the next
+// one is, if any is left
+func (s *scheduler) publishDue() (time.Time, bool) {
+	ctx := context.Background()
+	published, err := s.store.PublishDue(ctx, time.Now())
+	for _, item := range published {
+		fmt.Printf("Published scheduled blog %v\n", item.ID.Hex())
+	}
+	if err != nil {
+		fmt.Printf("Publishing scheduled blogs failed: %v\n", err)
+	}
+
+	var next time.Time
+	found := false
+	err = s.store.List(ctx, listOptions{Statuses: []string{statusScheduled}}, func(item *blogItem) error {
+		if item.PublishAt != nil && (!found || item.PublishAt.Before(next)) {
+			next, found = *item.PublishAt, true
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Listing scheduled blogs failed: %v\n", err)
+	}
+	return next, found
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// listStream collects what ListBlogs sends
+type listStream struct {
+	blogpb.BlogService_ListBlogsServer
+	ctx  context.Context
+	sent []*blogpb.ListBlogsResponse
+}
+
+func (s *listStream) Context() context.Context { return s.ctx }
+
+func (s *listStream) Send(res *blogpb.ListBlogsResponse) error {
+	s.sent = append(s.sent, res)
+	return nil
+}
+
+func TestDraftsNeedAdminToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string // sent as admin-token metadata
+		draft bool   // whether the call asks for drafts
+		read  codes.Code
+		list  codes.Code
+		seen  bool // whether the draft is listed
+	}{
+		{"plain client", "", false, codes.NotFound, codes.OK, false},
+		{"plain client asking for drafts", "", true, codes.PermissionDenied, codes.PermissionDenied, false},
+		{"wrong token", "guess", true, codes.PermissionDenied, codes.PermissionDenied, false},
+		{"admin", "secret", true, codes.OK, codes.OK, true},
+		{"admin not asking for drafts", "secret", false, codes.NotFound, codes.OK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			s := &server{store: tenantStore{}, adminToken: "secret"}
+			draft, err := (tenantStore{}).Create(ctx, &blogItem{Title: "draft", Status: statusDraft})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(adminMetadataKey, tt.token))
+			}
+
+			_, err = s.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: draft.ID.Hex(), IncludeUnpublished: tt.draft})
+			if status.Code(err) != tt.read {
+				t.Errorf("ReadBlog failed with %v, want %v", err, tt.read)
+			}
+
+			req := &blogpb.ListBlogsRequest{}
+			if tt.draft {
+				req.Statuses = []blogpb.Blog_Status{blogpb.Blog_PUBLISHED, blogpb.Blog_DRAFT}
+			}
+			stream := &listStream{ctx: ctx}
+			err = s.ListBlogs(req, stream)
+			if status.Code(err) != tt.list {
+				t.Errorf("ListBlogs failed with %v, want %v", err, tt.list)
+			}
+			if seen := len(stream.sent) > 0; seen != tt.seen {
+				t.Errorf("the draft was listed: %v, want %v", seen, tt.seen)
+			}
+		})
+	}
+}
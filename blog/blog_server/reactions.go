@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxUserIDLength = 100
+
+var reactionFromPb = map[blogpb.Reaction]string{
+	blogpb.Reaction_LIKE:  "like",
+	blogpb.Reaction_LOVE:  "love",
+	blogpb.Reaction_LAUGH: "laugh",
+	blogpb.Reaction_WOW:   "wow",
+	blogpb.Reaction_SAD:   "sad",
+	blogpb.Reaction_ANGRY: "angry",
+}
+
+var reactionToPb = map[string]blogpb.Reaction{
+	"like":  blogpb.Reaction_LIKE,
+	"love":  blogpb.Reaction_LOVE,
+	"laugh": blogpb.Reaction_LAUGH,
+	"wow":   blogpb.Reaction_WOW,
+	"sad":   blogpb.Reaction_SAD,
+	"angry": blogpb.Reaction_ANGRY,
+}
+
+// reactionItem is the reaction of one user on one blog
+type reactionItem struct {
+	ID       string             `bson:"_id"`
+	BlogID   primitive.ObjectID `bson:"blog_id"`
+	UserID   string             `bson:"user_id"`
+	Reaction string             `bson:"reaction"`
+}
+
+// reactionID is the id of what a user did on a blog, so there is only one
+// reaction and one view record per user and blog
+func reactionID(blogID primitive.ObjectID, user string) string {
+	return blogID.Hex() + "/" + user
+}
+
+// withReaction returns a copy of item with one less of reaction removed
+// and one more of reaction added, either of which may be ""
+func (item *blogItem) withReaction(removed, added string) *blogItem {
+	changed := *item
+	changed.Reactions = make(map[string]int64, len(item.Reactions)+1)
+	for r, n := range item.Reactions {
+		changed.Reactions[r] = n
+	}
+	if removed != "" {
+		changed.Reactions[removed]--
+		if changed.Reactions[removed] <= 0 {
+			delete(changed.Reactions, removed)
+		}
+	}
+	if added != "" {
+		changed.Reactions[added]++
+	}
+	return &changed
+}
+
+// dataToReactionsPb returns the reactions a blog got in the order of the
+// Reaction enum
+func dataToReactionsPb(data *blogItem) []*blogpb.ReactionCount {
+	var counts []*blogpb.ReactionCount
+	for r, n := range data.Reactions {
+		if pb, ok := reactionToPb[r]; ok && n > 0 {
+			counts = append(counts, &blogpb.ReactionCount{Reaction: pb, Count: n})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].GetReaction() < counts[j].GetReaction()
+	})
+	return counts
+}
+
+// getPublished reads a blog that readers may react to or view
+func (s *server) getPublished(ctx context.Context, id string) (primitive.ObjectID, error) {
+	oid, err := parseBlogID(id)
+	if err != nil {
+		return oid, err
+	}
+	data, err := s.store.Get(ctx, oid)
+	if err == nil && !data.published() {
+		err = errNotFound
+	}
+	if err != nil {
+		return oid, storeError(err, id)
+	}
+	return oid, nil
+}
+
+func (s *server) ReactToBlog(ctx context.Context, req *blogpb.ReactToBlogRequest) (*blogpb.ReactToBlogResponse, error) {
+	fmt.Println("React to blog request")
+
+	if err := validateReactToBlog(req); err != nil {
+		return nil, err
+	}
+	oid, err := s.getPublished(ctx, req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.React(ctx, oid, req.GetUserId(), reactionFromPb[req.GetReaction()])
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+	return &blogpb.ReactToBlogResponse{
+		Blog: dataToBlogPb(data),
+	}, nil
+}
+
+func (s *server) RemoveReaction(ctx context.Context, req *blogpb.RemoveReactionRequest) (*blogpb.RemoveReactionResponse, error) {
+	fmt.Println("Remove reaction request")
+
+	if err := validateRemoveReaction(req); err != nil {
+		return nil, err
+	}
+	oid, err := s.getPublished(ctx, req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.Unreact(ctx, oid, req.GetUserId())
+	if err == errReactionNotFound {
+		return nil, status.Errorf(
+			codes.NotFound,
+			fmt.Sprintf("User %v has no reaction on blog %v", req.GetUserId(), req.GetBlogId()),
+		)
+	}
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+	return &blogpb.RemoveReactionResponse{
+		Blog: dataToBlogPb(data),
+	}, nil
+}
+
+func (s *server) RecordView(ctx context.Context, req *blogpb.RecordViewRequest) (*blogpb.RecordViewResponse, error) {
+	fmt.Println("Record view request")
+
+	if err := validateRecordView(req); err != nil {
+		return nil, err
+	}
+	oid, err := s.getPublished(ctx, req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, counted, err := s.store.RecordView(ctx, oid, req.GetViewerId(), s.viewWindow)
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+	return &blogpb.RecordViewResponse{
+		Counted:   counted,
+		ViewCount: data.Views,
+	}, nil
+}
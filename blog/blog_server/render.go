@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+)
+
+func (s *server) RenderBlog(ctx context.Context, req *blogpb.RenderBlogRequest) (*blogpb.RenderBlogResponse, error) {
+	fmt.Println("Render blog request")
+
+	if err := validateBlogID(req.GetBlogId()); err != nil {
+		return nil, err
+	}
+	if err := checkUnpublished(ctx, s.adminToken, req.GetIncludeUnpublished()); err != nil {
+		return nil, err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.Get(ctx, oid)
+	if err == nil && !data.published() && !req.GetIncludeUnpublished() {
+		err = errNotFound
+	}
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	return &blogpb.RenderBlogResponse{
+		Blog:     dataToBlogPb(data),
+		Rendered: dataToRenderedPb(renderMarkdown(data.Content)),
+	}, nil
+}
+
+func dataToRenderedPb(data *renderedBlog) *blogpb.RenderedBlog {
+	toc := make([]*blogpb.TocEntry, 0, len(data.TOC))
+	for _, e := range data.TOC {
+		toc = append(toc, &blogpb.TocEntry{
+			Level:  int32(e.Level),
+			Title:  e.Title,
+			Anchor: e.Anchor,
+		})
+	}
+	return &blogpb.RenderedBlog{
+		Html:           data.HTML,
+		Toc:            toc,
+		WordCount:      int32(data.Words),
+		ReadingMinutes: int32(data.ReadingMinutes),
+	}
+}
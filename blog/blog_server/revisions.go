@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// revisionItem is an immutable copy of a blog as it was written at one
+// version. Stores record one on every write.
+type revisionItem struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID    primitive.ObjectID `bson:"blog_id"`
+	Version   int64              `bson:"version"`
+	AuthorID  string             `bson:"author_id"`
+	Title     string             `bson:"title"`
+	Content   string             `bson:"content"`
+	Tags      []string           `bson:"tags,omitempty"`
+	Category  string             `bson:"category,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// newRevision captures item as it is about to be stored
+func newRevision(item *blogItem) *revisionItem {
+	return &revisionItem{
+		ID:        primitive.NewObjectID(),
+		BlogID:    item.ID,
+		Version:   item.Version,
+		AuthorID:  item.AuthorID,
+		Title:     item.Title,
+		Content:   item.Content,
+		Tags:      item.Tags,
+		Category:  item.Category,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func (s *server) ListRevisions(req *blogpb.ListRevisionsRequest, stream blogpb.BlogService_ListRevisionsServer) error {
+	fmt.Printf("List revisions request %v\n", req)
+
+	if err := validateBlogID(req.GetBlogId()); err != nil {
+		return err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return err
+	}
+
+	sendErr := error(nil)
+	err = s.store.ListRevisions(stream.Context(), oid, func(rev *revisionItem) error {
+		sendErr = stream.Send(&blogpb.ListRevisionsResponse{
+			Revision: dataToRevisionPb(rev),
+		})
+		return sendErr
+	})
+	if err != nil {
+		if err == sendErr {
+			return err
+		}
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error: %v", err),
+		)
+	}
+	return nil
+}
+
+func (s *server) GetRevision(ctx context.Context, req *blogpb.GetRevisionRequest) (*blogpb.GetRevisionResponse, error) {
+	fmt.Println("Get revision request")
+
+	if err := validateRevision(req.GetBlogId(), req.GetVersion()); err != nil {
+		return nil, err
+	}
+	rev, err := s.revision(ctx, req.GetBlogId(), req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	return &blogpb.GetRevisionResponse{
+		Revision: dataToRevisionPb(rev),
+	}, nil
+}
+
+func (s *server) RestoreRevision(ctx context.Context, req *blogpb.RestoreRevisionRequest) (*blogpb.RestoreRevisionResponse, error) {
+	fmt.Println("Restore revision request")
+
+	if err := validateRevision(req.GetBlogId(), req.GetVersion()); err != nil {
+		return nil, err
+	}
+	rev, err := s.revision(ctx, req.GetBlogId(), req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.store.Get(ctx, rev.BlogID)
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	// the restore is an ordinary update on top of the current version,
+	// so it is rejected like any other if the blog moves on meanwhile
+	restored, err := s.store.Update(ctx, &blogItem{
+		ID:       rev.BlogID,
+		AuthorID: rev.AuthorID,
+		Title:    rev.Title,
+		Content:  rev.Content,
+		Version:  current.Version,
+		Tags:     rev.Tags,
+		Category: rev.Category,
+	}, nil)
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	return &blogpb.RestoreRevisionResponse{
+		Blog: dataToBlogPb(restored),
+	}, nil
+}
+
+func (s *server) DiffRevisions(ctx context.Context, req *blogpb.DiffRevisionsRequest) (*blogpb.DiffRevisionsResponse, error) {
+	fmt.Println("Diff revisions request")
+
+	if err := validateDiffRevisions(req); err != nil {
+		return nil, err
+	}
+	from, err := s.revision(ctx, req.GetBlogId(), req.GetFromVersion())
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.revision(ctx, req.GetBlogId(), req.GetToVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rev := range []*revisionItem{from, to} {
+		if lines := len(splitLines(rev.Content)); lines > maxDiffLines {
+			return nil, status.Errorf(
+				codes.ResourceExhausted,
+				fmt.Sprintf("Revision %v has %v lines, more than the %v that can be diffed", rev.Version, lines, maxDiffLines),
+			)
+		}
+	}
+
+	return &blogpb.DiffRevisionsResponse{
+		UnifiedDiff: unifiedDiff(
+			fmt.Sprintf("content@%v", from.Version),
+			fmt.Sprintf("content@%v", to.Version),
+			from.Content,
+			to.Content,
+		),
+	}, nil
+}
+
+// revision looks up one revision of a blog and turns failures into status
+// errors
+func (s *server) revision(ctx context.Context, blogID string, version int64) (*revisionItem, error) {
+	oid, err := parseBlogID(blogID)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := s.store.GetRevision(ctx, oid, version)
+	if err != nil {
+		if err == errRevisionNotFound {
+			return nil, status.Errorf(
+				codes.NotFound,
+				fmt.Sprintf("Cannot find revision %v of blog %v", version, blogID),
+			)
+		}
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+	return rev, nil
+}
+
+func dataToRevisionPb(data *revisionItem) *blogpb.Revision {
+	return &blogpb.Revision{
+		BlogId:    data.BlogID.Hex(),
+		Version:   data.Version,
+		AuthorId:  data.AuthorID,
+		Title:     data.Title,
+		Content:   data.Content,
+		CreatedAt: timestamppb.New(data.CreatedAt),
+		Tags:      data.Tags,
+		Category:  data.Category,
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags maps each tag sanitizeHTML keeps to the attributes it keeps
+// on it. Any other tag is dropped but its content stays.
+var allowedTags = map[string][]string{
+	"h1": {"id"}, "h2": {"id"}, "h3": {"id"}, "h4": {"id"}, "h5": {"id"}, "h6": {"id"},
+	"p": nil, "br": nil, "hr": nil, "blockquote": nil, "pre": nil, "code": {"class"},
+	"ul": nil, "ol": {"start"}, "li": nil,
+	"em": nil, "strong": nil, "b": nil, "i": nil, "u": nil, "s": nil, "del": nil,
+	"sub": nil, "sup": nil, "kbd": nil, "mark": nil, "abbr": {"title"},
+	"a":     {"href", "title"},
+	"img":   {"src", "alt", "title"},
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": {"align"}, "td": {"align"},
+	"details": nil, "summary": nil,
+}
+
+// droppedTags are removed together with everything inside them
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "textarea": true, "title": true,
+	"svg": true, "math": true, "frame": true, "frameset": true, "noembed": true,
+	"noframes": true, "xmp": true, "select": true, "form": true,
+}
+
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// sanitizeHTML keeps only the tags and attributes in allowedTags, drops
+// links to anything but http, https, mailto or relative URLs, and closes
+// every tag it keeps, so the result can be embedded in a page as is
+func sanitizeHTML(src string) string {
+	var out strings.Builder
+	var open []string // tags kept and not yet closed
+	skip := 0         // depth inside droppedTags
+
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		if z.Next() == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tok.Type {
+		case html.TextToken:
+			if skip == 0 {
+				out.WriteString(html.EscapeString(tok.Data))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedTags[tok.Data] {
+				if tok.Type == html.StartTagToken && !voidTags[tok.Data] {
+					skip++
+				}
+				continue
+			}
+			attrs, ok := allowedTags[tok.Data]
+			if skip > 0 || !ok {
+				continue
+			}
+			tok.Attr = cleanAttrs(tok.Data, tok.Attr, attrs)
+			if tok.Data == "a" {
+				tok.Attr = append(tok.Attr, html.Attribute{Key: "rel", Val: "nofollow noopener"})
+			}
+			if !voidTags[tok.Data] {
+				open = append(open, tok.Data)
+			}
+			tok.Type = html.StartTagToken
+			out.WriteString(tok.String())
+
+		case html.EndTagToken:
+			if droppedTags[tok.Data] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			// close the matching tag and whatever was left open inside it;
+			// an end tag without a matching start tag is dropped
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					out.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+		// comments and doctypes are dropped
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+// htmlText returns the text of HTML without its tags, as a browser shows
+// it. It is meant for sanitized HTML, which holds no script.
+func htmlText(src string) string {
+	var text strings.Builder
+	z := html.NewTokenizer(strings.NewReader(src))
+	for z.Next() != html.ErrorToken {
+		if tok := z.Token(); tok.Type == html.TextToken {
+			text.WriteString(tok.Data)
+		}
+	}
+	return text.String()
+}
+
+// cleanAttrs keeps the attributes of tag that are allowed and safe
+func cleanAttrs(tag string, attrs []html.Attribute, allowed []string) []html.Attribute {
+	var kept []html.Attribute
+	for _, a := range attrs {
+		if a.Namespace != "" || !containsString(allowed, a.Key) {
+			continue
+		}
+		switch a.Key {
+		case "href", "src":
+			if !safeURL(a.Val, tag == "a") {
+				continue
+			}
+		case "class":
+			// only the language of a code block
+			if !strings.HasPrefix(a.Val, "language-") || languageName(a.Val[len("language-"):]) == "" {
+				continue
+			}
+		case "id":
+			if a.Val == "" || a.Val != slugify(a.Val) {
+				continue
+			}
+		case "start":
+			if strings.Trim(a.Val, "0123456789") != "" {
+				continue
+			}
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// safeURL reports whether u is a relative URL or uses a scheme that cannot
+// run script. mailto is only allowed for links.
+func safeURL(u string, link bool) bool {
+	// browsers ignore whitespace and control characters in a scheme, as
+	// in "java\tscript:"
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, u)
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "", "http", "https":
+		return true
+	case "mailto":
+		return link
+	default:
+		return false
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"event handler", "<p onclick=x>hi</p>", "<p>hi</p>"},
+		{"script", "<script>x</script>ok", "ok"},
+		{"style", "<style>p{}</style><u>u</u>", "<u>u</u>"},
+		{"iframe", "<iframe src=x></iframe>", ""},
+		{"script href", "<a href=\"javascript:x\" title=t>l</a>", "<a title=\"t\" rel=\"nofollow noopener\">l</a>"},
+		{"tab in scheme", "<a href=\"jav&#x09;ascript:x\">l</a>", "<a rel=\"nofollow noopener\">l</a>"},
+		{"mailto link", "<a href=\"mailto:a@b\">m</a>", "<a href=\"mailto:a@b\" rel=\"nofollow noopener\">m</a>"},
+		{"mailto image", "<img src=\"mailto:a@b\">", "<img>"},
+		{"data image", "<img src=\"data:x\" alt=a>", "<img alt=\"a\">"},
+		{"unclosed", "<b><i>x</b>", "<b><i>x</i></b>"},
+		{"stray end tag", "</b>stray", "stray"},
+		{"bad start", "<ol start=\"3x\"><li>a</li></ol>", "<ol><li>a</li></ol>"},
+		{"language class", "<code class=\"language-go\">x</code>", "<code class=\"language-go\">x</code>"},
+		{"other class", "<code class=\"evil\">x</code>", "<code>x</code>"},
+		{"bad id", "<h1 id=\"Bad Id\">x</h1>", "<h1>x</h1>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeHTML(tt.src); got != tt.want {
+				t.Errorf("sanitizeHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// titleWeight is how much more a title match counts than a content match
+	titleWeight = 3.0
+	// snippetContext is the number of words kept on each side of a match
+	snippetContext = 8
+	maxSnippets    = 3
+
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+)
+
+// searchIndex is an inverted index over blog titles and content. It is
+// kept in process so search works the same on every BlogStore.
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[primitive.ObjectID]termFreq
+	// docs holds the version of each blog that is indexed, so its terms can
+	// be removed again and snippets cut from it
+	docs map[primitive.ObjectID]*blogItem
+}
+
+// termFreq counts how often a term occurs in one blog
+type termFreq struct {
+	title   int
+	content int
+}
+
+// searchHit is a blog that matched a query
+type searchHit struct {
+	item  *blogItem
+	score float64
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[primitive.ObjectID]termFreq),
+		docs:     make(map[primitive.ObjectID]*blogItem),
+	}
+}
+
+// add indexes item, replacing whatever was indexed under its id before.
+// Concurrent writers may call add out of order, so an item older than
+// the indexed one is ignored.
+func (x *searchIndex) add(item *blogItem) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.docs[item.ID]; ok && old.Version > item.Version {
+		return
+	}
+	x.removeLocked(item.ID)
+
+	freqs := make(map[string]termFreq)
+	for _, w := range words(item.Title) {
+		tf := freqs[w.term]
+		tf.title++
+		freqs[w.term] = tf
+	}
+	for _, w := range words(item.Content) {
+		tf := freqs[w.term]
+		tf.content++
+		freqs[w.term] = tf
+	}
+	for term, tf := range freqs {
+		p, ok := x.postings[term]
+		if !ok {
+			p = make(map[primitive.ObjectID]termFreq)
+			x.postings[term] = p
+		}
+		p[item.ID] = tf
+	}
+	x.docs[item.ID] = item
+}
+
+// refresh swaps in item for the indexed blog of the same version, for
+// writes that change neither the title nor the content, like reactions
+// and views. The terms stay as they are. Concurrent writes may refresh
+// out of order, so the counts in search results can lag a little.
+func (x *searchIndex) refresh(item *blogItem) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.docs[item.ID]; ok && old.Version == item.Version {
+		x.docs[item.ID] = item
+	}
+}
+
+func (x *searchIndex) remove(id primitive.ObjectID) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *searchIndex) removeLocked(id primitive.ObjectID) {
+	item, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for _, w := range append(words(item.Title), words(item.Content)...) {
+		if p, ok := x.postings[w.term]; ok {
+			delete(p, id)
+			if len(p) == 0 {
+				delete(x.postings, w.term)
+			}
+		}
+	}
+	delete(x.docs, id)
+}
+
+// search ranks the indexed blogs against terms and returns the best limit
+// of them. Each term adds its inverse document frequency, scaled by a
+// saturating count of its title and content occurrences, so rare words
+// and title matches count most while repetition has diminishing returns.
+func (x *searchIndex) search(terms []string, limit int) []searchHit {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	n := float64(len(x.docs))
+	scores := make(map[primitive.ObjectID]float64)
+	for _, term := range terms {
+		p := x.postings[term]
+		if len(p) == 0 {
+			continue
+		}
+		idf := math.Log(1 + n/float64(len(p)))
+		for id, tf := range p {
+			scores[id] += idf * (titleWeight*saturate(tf.title) + saturate(tf.content))
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, searchHit{item: x.docs[id], score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		// newest first among equals
+		return bytes.Compare(hits[i].item.ID[:], hits[j].item.ID[:]) > 0
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func saturate(tf int) float64 {
+	f := float64(tf)
+	return f / (f + 1.2)
+}
+
+// word is a searchable word in a text, with its byte offsets
+type word struct {
+	term       string
+	start, end int
+}
+
+// words splits text into lowercased runs of letters and digits
+func words(text string) []word {
+	var ws []word
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			ws = append(ws, word{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ws = append(ws, word{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return ws
+}
+
+// queryTerms returns the distinct terms of a search query
+func queryTerms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range words(query) {
+		if !seen[w.term] {
+			seen[w.term] = true
+			terms = append(terms, w.term)
+		}
+	}
+	return terms
+}
+
+// highlight HTML escapes text and wraps every word of it that is one of
+// terms in highlight markers
+func highlight(text string, terms map[string]bool) string {
+	ws := words(text)
+	return markRange(text, ws, 0, len(ws), terms)
+}
+
+// snippets cuts up to maxSnippets excerpts out of text, each centered on
+// matching words, HTML escaped and with the matches highlighted. Excerpts
+// that would overlap are merged.
+func snippets(text string, terms map[string]bool) []string {
+	ws := words(text)
+
+	type window struct{ lo, hi int }
+	var windows []window
+	for i, w := range ws {
+		if !terms[w.term] {
+			continue
+		}
+		lo, hi := i-snippetContext, i+snippetContext+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(ws) {
+			hi = len(ws)
+		}
+		if n := len(windows); n > 0 && lo <= windows[n-1].hi {
+			windows[n-1].hi = hi
+			continue
+		}
+		if len(windows) == maxSnippets {
+			break
+		}
+		windows = append(windows, window{lo, hi})
+	}
+
+	out := make([]string, 0, len(windows))
+	for _, w := range windows {
+		s := markRange(text, ws, w.lo, w.hi, terms)
+		if w.lo > 0 {
+			s = "…" + s
+		}
+		if w.hi < len(ws) {
+			s += "…"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// markRange returns the text spanning ws[lo:hi], HTML escaped, with
+// matching words wrapped in highlight markers. A range that reaches either
+// end of ws also keeps whatever punctuation lies before the first or after
+// the last word.
+func markRange(text string, ws []word, lo, hi int, terms map[string]bool) string {
+	if lo >= hi {
+		if lo == 0 {
+			return html.EscapeString(text)
+		}
+		return ""
+	}
+
+	from, to := ws[lo].start, ws[hi-1].end
+	if lo == 0 {
+		from = 0
+	}
+	if hi == len(ws) {
+		to = len(text)
+	}
+
+	var b strings.Builder
+	pos := from
+	for _, w := range ws[lo:hi] {
+		if !terms[w.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:w.start]))
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(text[w.start:w.end]))
+		b.WriteString(highlightEnd)
+		pos = w.end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	return b.String()
+}
+
+// indexedStore keeps a searchIndex in sync with every write that goes
+// through the BlogStore it wraps. Only published blogs are indexed.
+type indexedStore struct {
+	BlogStore
+	index *searchIndex
+}
+
+// reindex adds item to the index, or takes it out if it is not published
+func (s *indexedStore) reindex(item *blogItem) {
+	if item.published() {
+		s.index.add(item)
+	} else {
+		s.index.remove(item.ID)
+	}
+}
+
+// newIndexedStore wraps store and fills index with the blogs it already
+// holds
+func newIndexedStore(ctx context.Context, store BlogStore, index *searchIndex) (*indexedStore, error) {
+	err := store.List(ctx, listOptions{Statuses: []string{statusPublished}}, func(item *blogItem) error {
+		index.add(item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &indexedStore{BlogStore: store, index: index}, nil
+}
+
+func (s *indexedStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	created, err := s.BlogStore.Create(ctx, item)
+	if err == nil {
+		s.reindex(created)
+	}
+	return created, err
+}
+
+func (s *indexedStore) CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error) {
+	created, prior, err := s.BlogStore.CreateOnce(ctx, item, key)
+	if created != nil {
+		s.reindex(created)
+	}
+	return created, prior, err
+}
+
+func (s *indexedStore) Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error) {
+	updated, err := s.BlogStore.Update(ctx, item, fields)
+	if err == nil {
+		s.reindex(updated)
+	}
+	return updated, err
+}
+
+func (s *indexedStore) Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	restored, err := s.BlogStore.Restore(ctx, id)
+	if err == nil {
+		s.reindex(restored)
+	}
+	return restored, err
+}
+
+func (s *indexedStore) SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error) {
+	changed, err := s.BlogStore.SetStatus(ctx, id, from, to, publishAt)
+	if err == nil {
+		s.reindex(changed)
+	}
+	return changed, err
+}
+
+func (s *indexedStore) PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error) {
+	published, err := s.BlogStore.PublishDue(ctx, now)
+	for _, item := range published {
+		s.reindex(item)
+	}
+	return published, err
+}
+
+func (s *indexedStore) Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	trashed, err := s.BlogStore.Delete(ctx, id)
+	if err == nil {
+		s.index.remove(id)
+	}
+	return trashed, err
+}
+
+func (s *indexedStore) React(ctx context.Context, blogID primitive.ObjectID, user, reaction string) (*blogItem, error) {
+	changed, err := s.BlogStore.React(ctx, blogID, user, reaction)
+	if err == nil {
+		s.index.refresh(changed)
+	}
+	return changed, err
+}
+
+func (s *indexedStore) Unreact(ctx context.Context, blogID primitive.ObjectID, user string) (*blogItem, error) {
+	changed, err := s.BlogStore.Unreact(ctx, blogID, user)
+	if err == nil {
+		s.index.refresh(changed)
+	}
+	return changed, err
+}
+
+func (s *indexedStore) RecordView(ctx context.Context, blogID primitive.ObjectID, viewer string, window time.Duration) (*blogItem, bool, error) {
+	changed, counted, err := s.BlogStore.RecordView(ctx, blogID, viewer, window)
+	if counted {
+		s.index.refresh(changed)
+	}
+	return changed, counted, err
+}
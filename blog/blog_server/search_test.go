@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"match", "Hello Go world", "Hello <mark>Go</mark> world"},
+		{"no match", "Hello world", "Hello world"},
+		{"markup around a match", "<b>go</b> & co", "&lt;b&gt;<mark>go</mark>&lt;/b&gt; &amp; co"},
+		{"markup only", `<script>"x"</script>`, "&lt;script&gt;&#34;x&#34;&lt;/script&gt;"},
+		{"no words", "<>", "&lt;&gt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.text, map[string]bool{"go": true}); got != tt.want {
+				t.Errorf("highlight(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"whole text", "learn go <fast>", []string{"learn <mark>go</mark> &lt;fast&gt;"}},
+		{"cut", "a b c d e f g h i j go <k> l m n o p q r s t",
+			[]string{"…c d e f g h i j <mark>go</mark> &lt;k&gt; l m n o p q r…"}},
+		{"no match", "nothing here", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippets(tt.text, map[string]bool{"go": true}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snippets(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	tests := []struct {
+		name  string
+		blogs []*blogItem
+		query string
+		want  []int // indexes into blogs, best first
+	}{
+		{"title above content", []*blogItem{
+			{Title: "Notes", Content: "about the gopher"},
+			{Title: "The gopher", Content: "notes"},
+		}, "gopher", []int{1, 0}},
+		{"rare word above common word", []*blogItem{
+			{Title: "Go", Content: "channels"},
+			{Title: "Go", Content: "generics"},
+			{Title: "Go", Content: "generics"},
+		}, "go channels", []int{0, 2, 1}},
+		{"no match", []*blogItem{{Title: "Go"}}, "rust", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := newSearchIndex()
+			for _, item := range tt.blogs {
+				item.ID = primitive.NewObjectID()
+				x.add(item)
+			}
+			var got []int
+			for _, hit := range x.search(queryTerms(tt.query), 10) {
+				for i, item := range tt.blogs {
+					if item == hit.item {
+						got = append(got, i)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("search(%q) ranked %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchFollowsWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(ctx context.Context, item *blogItem) error
+		found map[string]bool // whether each query finds the blog afterwards
+	}{
+		{"created", func(context.Context, *blogItem) error { return nil },
+			map[string]bool{"old": true}},
+		{"updated", func(ctx context.Context, item *blogItem) error {
+			_, err := tenantStore{}.Update(ctx, &blogItem{ID: item.ID, Title: "New title", Version: item.Version}, []string{"title"})
+			return err
+		}, map[string]bool{"old": false, "new": true}},
+		{"unpublished", func(ctx context.Context, item *blogItem) error {
+			_, err := tenantStore{}.SetStatus(ctx, item.ID, statusPublished, statusDraft, nil)
+			return err
+		}, map[string]bool{"old": false}},
+		{"deleted", func(ctx context.Context, item *blogItem) error {
+			_, err := tenantStore{}.Delete(ctx, item.ID)
+			return err
+		}, map[string]bool{"old": false}},
+		{"restored", func(ctx context.Context, item *blogItem) error {
+			if _, err := (tenantStore{}).Delete(ctx, item.ID); err != nil {
+				return err
+			}
+			_, err := tenantStore{}.Restore(ctx, item.ID)
+			return err
+		}, map[string]bool{"old": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			s := &server{store: tenantStore{}}
+			item, err := (tenantStore{}).Create(ctx, &blogItem{Title: "Old title", Status: statusPublished})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.write(ctx, item); err != nil {
+				t.Fatal(err)
+			}
+			for query, want := range tt.found {
+				res, err := s.SearchBlogs(ctx, &blogpb.SearchBlogsRequest{Query: query})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found := len(res.GetResults()) > 0; found != want {
+					t.Errorf("search for %q found the blog: %v, want %v", query, found, want)
+				}
+			}
+		})
+	}
+}
@@ -2,24 +2,38 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"grpc-go-course/blog/blogpb"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-var collection *mongo.Collection
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+)
 
 type server struct {
+	blogpb.UnimplementedBlogServiceServer
+
+	store BlogStore
+	// adminToken lets a call read blogs that are not published
+	adminToken string
+	// idempotencyWindow is how long CreateBlog remembers idempotency keys
+	idempotencyWindow time.Duration
+	// viewWindow is how long RecordView ignores repeat views by a viewer
+	viewWindow time.Duration
 }
 
 type blogItem struct {
@@ -27,72 +41,435 @@ type blogItem struct {
 	AuthorID string             `bson:"author_id"`
 	Content  string             `bson:"content"`
 	Title    string             `bson:"title"`
+	Version  int64              `bson:"version"`
+	Tags     []string           `bson:"tags,omitempty"`
+	Category string             `bson:"category,omitempty"`
+	// DeletedAt is set while the blog is in the trash
+	DeletedAt *time.Time `bson:"deleted_at,omitempty"`
+	// Status is empty on blogs written before there were drafts, which
+	// count as published
+	Status    string     `bson:"status,omitempty"`
+	PublishAt *time.Time `bson:"publish_at,omitempty"`
+	// Slugs holds every slug the blog has had, the current one last
+	Slugs []string `bson:"slugs,omitempty"`
+	// Reactions counts the reactions on the blog by name, and Views the
+	// views counted by RecordView
+	Reactions map[string]int64 `bson:"reactions,omitempty"`
+	Views     int64            `bson:"views,omitempty"`
+	// CreatedAt is zero on blogs from before it was recorded that were
+	// not migrated, whose id tells when they were created instead
+	CreatedAt time.Time `bson:"created_at,omitempty"`
+	// UpdatedAt is when the blog was last updated, zero if it never was
+	UpdatedAt time.Time `bson:"updated_at,omitempty"`
+}
+
+func (item *blogItem) createdAt() time.Time {
+	if item.CreatedAt.IsZero() {
+		return item.ID.Timestamp()
+	}
+	return item.CreatedAt
+}
+
+// publishedAt returns when the blog was published, or created for blogs
+// from before drafts
+func (item *blogItem) publishedAt() time.Time {
+	if item.PublishAt != nil {
+		return *item.PublishAt
+	}
+	return item.createdAt()
 }
 
-func (*server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
+// modifiedAt returns when the published blog last changed: when it was
+// published, or updated if that came later
+func (item *blogItem) modifiedAt() time.Time {
+	if at := item.publishedAt(); at.After(item.UpdatedAt) {
+		return at
+	}
+	return item.UpdatedAt
+}
+
+func (s *server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
 	fmt.Println("Blog Create has started")
 
+	if err := validateCreateBlog(req); err != nil {
+		return nil, err
+	}
+	key, err := idempotencyKey(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	blog := req.GetBlog()
+	author, err := checkAuthor(ctx, s.store, blog.GetAuthorId())
+	if err != nil {
+		return nil, err
+	}
+
+	st, publishAt, err := initialStatus(blog)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &blogItem{
+		AuthorID:  blog.GetAuthorId(),
+		Title:     blog.GetTitle(),
+		Content:   blog.GetContent(),
+		Tags:      normalizeTags(blog.GetTags()),
+		Category:  blog.GetCategory(),
+		Status:    st,
+		PublishAt: publishAt,
+	}
+
+	var created *blogItem
+	if key != "" {
+		created, err = s.createOnce(ctx, data, key, blog)
+	} else {
+		created, err = s.store.Create(ctx, data)
+		if err != nil {
+			err = status.Errorf(
+				codes.Internal,
+				fmt.Sprintf("Internal error: %v", err),
+			)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if created.Status == statusScheduled {
+		wakeScheduler(ctx)
+	}
+
+	res := &blogpb.CreateBlogResponse{
+		Blog: dataToBlogPb(created),
+	}
+	res.Blog.AuthorName = author.DisplayName
+	return res, nil
+}
+
+func (s *server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
+	fmt.Println("Read blog request")
+
+	if err := validateReadBlog(req); err != nil {
+		return nil, err
+	}
+	if err := checkUnpublished(ctx, s.adminToken, req.GetIncludeUnpublished()); err != nil {
+		return nil, err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.Get(ctx, oid)
+	if err == nil && !data.published() && !req.GetIncludeUnpublished() {
+		err = errNotFound
+	}
+	if err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	res := &blogpb.ReadBlogResponse{
+		Blog: dataToBlogPb(data),
+	}
+	if req.GetIncludeAuthor() {
+		newAuthorNames(s.store).fill(ctx, res.Blog)
+	}
+	if req.GetRender() {
+		res.Rendered = dataToRenderedPb(renderMarkdown(data.Content))
+	}
+	applyReadMask(res.Blog, req.GetReadMask())
+	return res, nil
+}
+
+func (s *server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
+	fmt.Println("Update blog request")
+
+	if err := validateUpdateBlog(req); err != nil {
+		return nil, err
+	}
 	blog := req.GetBlog()
+	oid, err := parseBlogID(blog.GetId())
+	if err != nil {
+		return nil, err
+	}
+	fields := updateFields(req.GetUpdateMask())
+	var author *authorItem
+	if fields == nil || contains(fields, "author_id") {
+		if author, err = checkAuthor(ctx, s.store, blog.GetAuthorId()); err != nil {
+			return nil, err
+		}
+	}
 
-	data := blogItem{
+	data, err := s.store.Update(ctx, &blogItem{
+		ID:       oid,
 		AuthorID: blog.GetAuthorId(),
 		Title:    blog.GetTitle(),
 		Content:  blog.GetContent(),
+		Version:  blog.GetVersion(),
+		Tags:     normalizeTags(blog.GetTags()),
+		Category: blog.GetCategory(),
+	}, fields)
+	if err != nil {
+		return nil, storeError(err, blog.GetId())
+	}
+
+	res := &blogpb.UpdateBlogResponse{
+		Blog: dataToBlogPb(data),
 	}
+	if author != nil {
+		res.Blog.AuthorName = author.DisplayName
+	} else {
+		newAuthorNames(s.store).fill(ctx, res.Blog)
+	}
+	return res, nil
+}
 
-	res, err := collection.InsertOne(context.Background(), data)
+func (s *server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
+	fmt.Println("Delete blog request")
+
+	if err := validateBlogID(req.GetBlogId()); err != nil {
+		return nil, err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.store.Delete(ctx, oid); err != nil {
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	return &blogpb.DeleteBlogResponse{BlogId: req.GetBlogId()}, nil
+}
+
+func (s *server) ListBlogs(req *blogpb.ListBlogsRequest, stream blogpb.BlogService_ListBlogsServer) error {
+	fmt.Printf("List blogs request %v\n", req)
+
+	if err := validateListBlogs(req); err != nil {
+		return err
+	}
+
+	order := req.GetSortOrder()
+	after, err := decodeCursor(req.GetCursor(), order)
+	if err != nil {
+		return err
+	}
+
+	opts := listOptions{
+		After:        after,
+		Descending:   order == blogpb.ListBlogsRequest_NEWEST_FIRST,
+		Limit:        int(req.GetPageSize()),
+		Tags:         normalizeTags(req.GetTags()),
+		MatchAllTags: req.GetTagMatch() == blogpb.ListBlogsRequest_ALL,
+		Category:     req.GetCategory(),
+		AuthorID:     req.GetAuthorId(),
+		Statuses:     listStatuses(req.GetStatuses()),
+		Fields:       storedFields(req.GetReadMask()),
+	}
+	if err := checkStatuses(stream.Context(), s.adminToken, opts.Statuses); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	names := newAuthorNames(s.store)
+	sendErr := error(nil)
+	err = s.store.List(ctx, opts, func(data *blogItem) error {
+		res := &blogpb.ListBlogsResponse{
+			Blog:   dataToBlogPb(data),
+			Cursor: encodeCursor(order, data.ID),
+		}
+		if req.GetIncludeAuthor() {
+			names.fill(ctx, res.Blog)
+		}
+		applyReadMask(res.Blog, req.GetReadMask())
+		sendErr = stream.Send(res)
+		return sendErr
+	})
 	if err != nil {
-		return nil, status.Errorf(
+		if err == sendErr {
+			return err
+		}
+		return status.Errorf(
 			codes.Internal,
-			fmt.Sprintf("Internal error: %v", err),
+			fmt.Sprintf("Unknown internal error: %v", err),
 		)
 	}
-	oid, ok := res.InsertedID.(primitive.ObjectID)
-	if ok {
-		data.ID = oid
-	} else {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Cannot convert to OID"),
+	return nil
+}
+
+func (s *server) SearchBlogs(ctx context.Context, req *blogpb.SearchBlogsRequest) (*blogpb.SearchBlogsResponse, error) {
+	fmt.Printf("Search blogs request %v\n", req)
+
+	if err := validateSearchBlogs(req); err != nil {
+		return nil, err
+	}
+	terms := queryTerms(req.GetQuery())
+	if len(terms) == 0 {
+		return nil, fieldError("query", "has no words to search for: %q", req.GetQuery())
+	}
+
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	match := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		match[term] = true
+	}
+
+	t, err := tenantFrom(ctx)
+	if err != nil {
+		return nil, storeError(err, "")
+	}
+	res := &blogpb.SearchBlogsResponse{}
+	for _, hit := range t.index.search(terms, limit) {
+		res.Results = append(res.Results, &blogpb.SearchBlogsResult{
+			Blog:           dataToBlogPb(hit.item),
+			Score:          hit.score,
+			TitleHighlight: highlight(hit.item.Title, match),
+			Snippets:       snippets(hit.item.Content, match),
+		})
+	}
+	return res, nil
+}
+
+// parseBlogID converts a hex string into an ObjectID, returning an
+// InvalidArgument status error when the string is malformed
+func parseBlogID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, status.Errorf(
+			codes.InvalidArgument,
+			fmt.Sprintf("Cannot parse ID: %v", id),
 		)
 	}
+	return oid, nil
+}
 
-	return &blogpb.CreateBlogResponse{
-		Blog: &blogpb.Blog{
-			Id:       oid.Hex(),
-			AuthorId: blog.GetAuthorId(),
-			Title:    blog.Title,
-			Content:  blog.GetContent(),
-		},
-	}, nil
+// storeError turns an error returned by a BlogStore into a status error
+func storeError(err error, id string) error {
+	if err == errNotFound {
+		return status.Errorf(
+			codes.NotFound,
+			fmt.Sprintf("Cannot find blog with specified ID: %v", id),
+		)
+	}
+	if err == errVersionConflict {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			fmt.Sprintf("Blog %v was changed by someone else, read it again and retry", id),
+		)
+	}
+	return status.Errorf(
+		codes.Internal,
+		fmt.Sprintf("Internal error: %v", err),
+	)
+}
+
+func dataToBlogPb(data *blogItem) *blogpb.Blog {
+	blog := &blogpb.Blog{
+		Id:       data.ID.Hex(),
+		AuthorId: data.AuthorID,
+		Title:    data.Title,
+		Content:  data.Content,
+		Version:  data.Version,
+		Tags:     data.Tags,
+		Category: data.Category,
+	}
+	if data.DeletedAt != nil {
+		blog.DeletedAt = timestamppb.New(*data.DeletedAt)
+	}
+	blog.Status = statusToPb[data.status()]
+	blog.PublishAt = dataToPublishAtPb(data)
+	blog.Slug = data.slug()
+	blog.Reactions = dataToReactionsPb(data)
+	blog.ViewCount = data.Views
+	blog.CreatedAt = timestamppb.New(data.createdAt())
+	return blog
 }
 
 func main() {
 	// if  we crash the go code, we get the file name and line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	// connect to mongodb
-	fmt.Println("Connecting to MongoDB")
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	cfg := storeConfig{}
+	flag.StringVar(&cfg.Kind, "store", "mongo", "blog storage backend: mongo, memory or file")
+	flag.StringVar(&cfg.MongoURI, "mongo-uri", "mongodb://localhost:27017", "MongoDB connection string")
+	flag.StringVar(&cfg.DataFile, "data-file", "blog.db", "log file used by the file store")
+	flag.DurationVar(&cfg.CompactEvery, "compact-every", 10*time.Minute, "how often the file store compacts its log, 0 to disable")
+	flag.BoolVar(&cfg.MigrateDryRun, "migrate-dry-run", false, "print the MongoDB migrations that are due and exit without applying them")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted blogs stay in the trash before they are purged")
+	purgeEvery := flag.Duration("purge-every", time.Hour, "how often the trash is checked for blogs to purge")
+	scheduleCheck := flag.Duration("schedule-check", time.Minute, "how often scheduled blogs are looked up again, besides when they are due")
+	idempotencyWindow := flag.Duration("idempotency-window", 24*time.Hour, "how long CreateBlog remembers an idempotency key")
+	viewWindow := flag.Duration("view-window", 30*time.Minute, "how long repeat views of a blog by the same viewer are not counted")
+	attachmentDir := flag.String("attachment-dir", "attachments", "directory the content of attachments is stored in")
+	maxAttachmentSize := flag.Int64("max-attachment-size", 10<<20, "largest attachment accepted, in bytes")
+	watchBuffer := flag.Int("watch-buffer", 1024, "how many recent changes WatchBlogs keeps for clients that resume")
+	tenantsFile := flag.String("tenants", "", "JSON file listing the tenants and where their data lives, empty for a single tenant")
+	adminToken := flag.String("admin-token", "", "token TenantService calls and calls for unpublished blogs must carry, empty to turn them off")
+	cacheSize := flag.Int("cache-size", 1000, "how many blogs each tenant keeps cached for reads, 0 to turn the cache off")
+	cacheTTL := flag.Duration("cache-ttl", 30*time.Second, "how long a cached blog or feed is served before it is read from the store again")
+	httpAddr := flag.String("http-addr", "", "address to serve RSS and Atom feeds and the sitemap on over HTTP, e.g. :8080, empty to not serve them")
+	publicURL := flag.String("public-url", "", "URL the blogs are published under, linked from feeds and the sitemap; empty for the host of each request")
+	feedTitle := flag.String("feed-title", "Blog", "title of the feeds")
+	flag.Parse()
+
+	configs, err := loadTenants(*tenantsFile, cfg, *attachmentDir)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Failed to load the tenants: %v", err)
+	}
+	if cfg.MigrateDryRun {
+		if cfg.Kind != "mongo" {
+			fmt.Printf("The %v store has no schema to migrate\n", cfg.Kind)
+			return
+		}
+		for _, tc := range configs {
+			_, closeStore, err := openStore(cfg, tc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			closeStore()
+		}
+		return
 	}
-	err = client.Connect(context.TODO())
+
+	ts, err := openTenants(cfg, configs, tenantOptions{
+		WatchBuffer:    *watchBuffer,
+		ScheduleCheck:  *scheduleCheck,
+		TrashRetention: *trashRetention,
+		PurgeEvery:     *purgeEvery,
+		CacheSize:      *cacheSize,
+		CacheTTL:       *cacheTTL,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Println("Blog Service Started")
-	collection = client.Database("myblogdb").Collection("blog")
+	fmt.Printf("Blog Service Started with %v store for %v tenants\n", cfg.Kind, len(ts))
 
 	lis, err := net.Listen("tcp", "0.0.0.0:50051")
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	opts := []grpc.ServerOption{}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(ts.unaryInterceptor),
+		grpc.StreamInterceptor(ts.streamInterceptor),
+	}
 	s := grpc.NewServer(opts...)
-	blogpb.RegisterBlogServiceServer(s, &server{})
+	blogpb.RegisterBlogServiceServer(s, &server{
+		store:             tenantStore{},
+		adminToken:        *adminToken,
+		idempotencyWindow: *idempotencyWindow,
+		viewWindow:        *viewWindow,
+	})
+	blogpb.RegisterCommentServiceServer(s, &commentServer{store: tenantStore{}, authors: tenantStore{}})
+	blogpb.RegisterAuthorServiceServer(s, &authorServer{store: tenantStore{}})
+	blogpb.RegisterAttachmentServiceServer(s, &attachmentServer{store: tenantStore{}, maxSize: *maxAttachmentSize, adminToken: *adminToken})
+	blogpb.RegisterTenantServiceServer(s, &tenantServer{tenants: ts, kind: cfg.Kind, adminToken: *adminToken})
 
 	go func() {
 		fmt.Println("Starting Server...")
@@ -101,6 +478,24 @@ func main() {
 		}
 	}()
 
+	var httpServer *http.Server
+	if *httpAddr != "" {
+		feeds := &feedServer{
+			tenants:   ts,
+			store:     tenantStore{},
+			title:     *feedTitle,
+			publicURL: *publicURL,
+			maxAge:    *cacheTTL,
+		}
+		httpServer = &http.Server{Addr: *httpAddr, Handler: feeds.handler()}
+		go func() {
+			fmt.Printf("Serving feeds on %v\n", *httpAddr)
+			if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalf("failed to serve feeds: %v", err)
+			}
+		}()
+	}
+
 	// Wait for Control C to exit
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
@@ -112,8 +507,12 @@ func main() {
 	s.Stop()
 	fmt.Println("Closing the listener")
 	lis.Close()
-	fmt.Println("Closing MongoDB collection")
-	client.Disconnect(context.Background())
+	if httpServer != nil {
+		fmt.Println("Stopping the feeds")
+		httpServer.Close()
+	}
+	fmt.Println("Closing the blog stores")
+	ts.close()
 
 	fmt.Println("End of Program")
 
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// testTenants opens a tenant on a memory store for each of ids, closed
+// when the test ends
+func testTenants(t *testing.T, ids ...string) tenants {
+	dir := t.TempDir()
+	var configs []tenantConfig
+	for _, id := range ids {
+		configs = append(configs, tenantConfig{ID: id, AttachmentDir: filepath.Join(dir, "tenant-"+id)})
+	}
+	ts, err := openTenants(storeConfig{Kind: "memory"}, configs, tenantOptions{
+		WatchBuffer:    100,
+		ScheduleCheck:  time.Hour,
+		TrashRetention: time.Hour,
+		PurgeEvery:     time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ts.close)
+	return ts
+}
+
+// testContext returns a context for calls made for tenant id of ts
+func testContext(t *testing.T, ts tenants, id string) context.Context {
+	ctx, err := ts.withTenant(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+// testAuthor creates an author in the tenant of ctx and returns its id
+func testAuthor(t *testing.T, ctx context.Context) string {
+	author, err := tenantStore{}.CreateAuthor(ctx, &authorItem{DisplayName: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return author.ID.Hex()
+}
+
+func TestUpdateBlogVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version func(current int64) int64 // the update is based on
+		code    codes.Code
+	}{
+		{"current", func(v int64) int64 { return v }, codes.OK},
+		{"stale", func(v int64) int64 { return v - 1 }, codes.FailedPrecondition},
+		{"ahead", func(v int64) int64 { return v + 1 }, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			s := &server{store: tenantStore{}}
+			author := testAuthor(t, ctx)
+			created, err := s.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{AuthorId: author, Title: "t", Content: "c", Status: blogpb.Blog_PUBLISHED}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a second write moves the blog past the version it was created at
+			blog := created.GetBlog()
+			blog.Content = "first edit"
+			first, err := s.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{Blog: blog})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blog = proto.Clone(first.GetBlog()).(*blogpb.Blog)
+			blog.Content = "second edit"
+			blog.Version = tt.version(first.GetBlog().GetVersion())
+			res, err := s.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{Blog: blog})
+			if status.Code(err) != tt.code {
+				t.Fatalf("UpdateBlog failed with %v, want %v", err, tt.code)
+			}
+
+			got, err := s.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: blog.GetId()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := first.GetBlog()
+			if tt.code == codes.OK {
+				want = res.GetBlog()
+			}
+			if got.GetBlog().GetContent() != want.GetContent() || got.GetBlog().GetVersion() != want.GetVersion() {
+				t.Errorf("the blog is at %q, version %v, want %q, version %v",
+					got.GetBlog().GetContent(), got.GetBlog().GetVersion(), want.GetContent(), want.GetVersion())
+			}
+		})
+	}
+}
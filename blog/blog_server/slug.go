@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxSlugLength bounds the part of a slug made from the title; a number
+// added to make it unique comes on top
+const maxSlugLength = 60
+
+// transliterations spell letters outside ASCII the way they are usually
+// written in URLs, starting with the Turkish ones
+var transliterations = map[rune]string{
+	'ç': "c", 'ğ': "g", 'ı': "i", 'ö': "o", 'ş': "s", 'ü': "u",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ß': "ss", 'ť': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// titleSlug turns a title into the lowercase ASCII words of a slug, so
+// "İpek'in Güzel Günleri" becomes "ipek-in-guzel-gunleri". Letters it
+// cannot spell in ASCII are left out.
+func titleSlug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(title) {
+		var s string
+		switch {
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+			s = string(c)
+		case transliterations[c] != "":
+			s = transliterations[c]
+		case c == '\u0307':
+			// the dot left over from lowercasing a dotted capital I
+			continue
+		default:
+			dash = true
+			continue
+		}
+		sep := dash && b.Len() > 0
+		if sep && b.Len()+1+len(s) > maxSlugLength {
+			break
+		}
+		if !sep && b.Len()+len(s) > maxSlugLength {
+			// drop the word that does not fit whole
+			if i := strings.LastIndexByte(b.String(), '-'); i > 0 {
+				return b.String()[:i]
+			}
+			break
+		}
+		if sep {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteString(s)
+	}
+	if b.Len() == 0 {
+		return "blog"
+	}
+	return b.String()
+}
+
+// slugCandidate returns the nth slug to try for a blog whose title gives
+// base: base itself, then base-2, base-3 and so on
+func slugCandidate(base string, n int) string {
+	if n == 1 {
+		return base
+	}
+	return fmt.Sprintf("%v-%v", base, n)
+}
+
+// slug returns the current slug of the blog, "" for a blog from before
+// there were slugs that has not been updated since
+func (item *blogItem) slug() string {
+	if len(item.Slugs) == 0 {
+		return ""
+	}
+	return item.Slugs[len(item.Slugs)-1]
+}
+
+// withSlug returns slugs with slug moved or added to the end, as the
+// current one
+func withSlug(slugs []string, slug string) []string {
+	out := make([]string, 0, len(slugs)+1)
+	for _, s := range slugs {
+		if s != slug {
+			out = append(out, s)
+		}
+	}
+	return append(out, slug)
+}
+
+// needsSlug reports whether writing item over old has to pick a new slug
+func needsSlug(old, item *blogItem) bool {
+	return old.slug() == "" || old.Title != item.Title
+}
+
+func (s *server) GetBlogBySlug(ctx context.Context, req *blogpb.GetBlogBySlugRequest) (*blogpb.GetBlogBySlugResponse, error) {
+	fmt.Println("Get blog by slug request")
+
+	if err := validateGetBlogBySlug(req); err != nil {
+		return nil, err
+	}
+
+	if err := checkUnpublished(ctx, s.adminToken, req.GetIncludeUnpublished()); err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.GetBySlug(ctx, req.GetSlug())
+	if err == nil && !data.published() && !req.GetIncludeUnpublished() {
+		err = errNotFound
+	}
+	if err == errNotFound {
+		return nil, status.Errorf(
+			codes.NotFound,
+			fmt.Sprintf("Cannot find blog with slug: %v", req.GetSlug()),
+		)
+	}
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Internal error: %v", err),
+		)
+	}
+
+	res := &blogpb.GetBlogBySlugResponse{
+		Blog:  dataToBlogPb(data),
+		Moved: data.slug() != req.GetSlug(),
+	}
+	if req.GetIncludeAuthor() {
+		newAuthorNames(s.store).fill(ctx, res.Blog)
+	}
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"grpc-go-course/blog/blogpb"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTitleSlug(t *testing.T) {
+	tests := []struct {
+		title string
+		slug  string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"Go 1.15 is out", "go-1-15-is-out"},
+		{"İpek'in Güzel Günleri", "ipek-in-guzel-gunleri"},
+		{"Straße über Köln", "strasse-uber-koln"},
+		{"Ærø og Þórshöfn", "aero-og-thorshofn"},
+		{"  --Trimmed--  ", "trimmed"},
+		{"日本語 notes", "notes"},
+		{"日本語", "blog"},
+		{"!!!", "blog"},
+		{strings.Repeat("word ", 20), strings.TrimSuffix(strings.Repeat("word-", 12), "-")},
+		{strings.Repeat("a", 70), strings.Repeat("a", 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			if got := titleSlug(tt.title); got != tt.slug {
+				t.Errorf("titleSlug(%q) = %q, want %q", tt.title, got, tt.slug)
+			}
+		})
+	}
+}
+
+func TestSlugCollisions(t *testing.T) {
+	ts := testTenants(t, "a")
+	ctx := testContext(t, ts, "a")
+	s := &server{store: tenantStore{}}
+	author := testAuthor(t, ctx)
+	create := func(title string) *blogpb.Blog {
+		res, err := s.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{
+			AuthorId: author, Title: title, Content: "c", Status: blogpb.Blog_PUBLISHED,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.GetBlog()
+	}
+
+	first, second, third := create("Hello World"), create("Hello, world!"), create("hello world")
+	renamed := proto.Clone(first).(*blogpb.Blog)
+	renamed.Title = "New name"
+	res, err := s.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{Blog: renamed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth := create("Hello World")
+
+	tests := []struct {
+		name string
+		blog *blogpb.Blog
+		slug string
+	}{
+		{"first", first, "hello-world"},
+		{"same words", second, "hello-world-2"},
+		{"same words again", third, "hello-world-3"},
+		{"renamed", res.GetBlog(), "new-name"},
+		// the old slug of a renamed blog still leads to it
+		{"after a rename", fourth, "hello-world-4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.blog.GetSlug() != tt.slug {
+				t.Errorf("slug = %q, want %q", tt.blog.GetSlug(), tt.slug)
+			}
+		})
+	}
+
+	got, err := s.GetBlogBySlug(ctx, &blogpb.GetBlogBySlugRequest{Slug: "hello-world"})
+	if err != nil || got.GetBlog().GetId() != first.GetId() {
+		t.Errorf("the old slug leads to %v, %v, want the renamed blog %v", got.GetBlog().GetId(), err, first.GetId())
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// errNotFound is returned by a BlogStore when no blog has the requested id
+	errNotFound = errors.New("blog not found")
+	// errCommentNotFound is returned when no comment has the requested id
+	errCommentNotFound = errors.New("comment not found")
+	// errVersionConflict is returned by Update when the stored blog is no
+	// longer at the version the update was based on
+	errVersionConflict = errors.New("blog version conflict")
+	// errRevisionNotFound is returned when a blog has no revision with the
+	// requested version
+	errRevisionNotFound = errors.New("revision not found")
+	// errAuthorNotFound is returned when no author has the requested id
+	errAuthorNotFound = errors.New("author not found")
+	// errStatusConflict is returned by SetStatus when the blog no longer
+	// has the status the change was based on
+	errStatusConflict = errors.New("blog status conflict")
+	// errAttachmentNotFound is returned when no attachment has the
+	// requested id
+	errAttachmentNotFound = errors.New("attachment not found")
+	// errReactionNotFound is returned by Unreact when the user has no
+	// reaction on the blog
+	errReactionNotFound = errors.New("reaction not found")
+)
+
+// BlogStore persists blog items. Implementations must be safe for
+// concurrent use, and assign ObjectIDs on Create so ids look the same
+// whichever backend is in use. Every Create and Update also records a
+// revision of what was written.
+//
+// Deleted blogs go to the trash: Get, Update, Delete and List treat them
+// as missing until they are restored, and only Purge removes them for good.
+type BlogStore interface {
+	// Create stores item at version 1 and sets CreatedAt. Create and Update give a blog a
+	// slug made from its title that no other blog has or had, and Update
+	// keeps the slugs a blog had before its title changed.
+	Create(ctx context.Context, item *blogItem) (*blogItem, error)
+	// CreateOnce is Create for a request carrying an idempotency key. If
+	// an unexpired record of the key exists it returns that record and
+	// creates nothing; otherwise it creates item and stores key, with
+	// key.Blog set to the created blog, alongside it. A record it returns
+	// is Pending if the blog of the key is still being created.
+	CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*blogItem, error)
+	// GetBySlug finds the blog that has or had slug
+	GetBySlug(ctx context.Context, slug string) (*blogItem, error)
+	// Update overwrites the fields of the blog listed in fields, or all of
+	// blogFields if it is nil, only if the blog is still at item.Version
+	// and bumps the version, as one atomic step
+	Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error)
+	// Delete moves a blog to the trash and returns it as it is there
+	Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error)
+	// List calls fn for each blog in id order until fn returns an error,
+	// which List then returns
+	List(ctx context.Context, opts listOptions, fn func(*blogItem) error) error
+	// ListTags returns how many published blogs outside the trash use
+	// each tag. The counts are kept up to date on every write instead of
+	// being counted on each call.
+	ListTags(ctx context.Context) ([]tagCount, error)
+
+	// ListTrash calls fn for each trashed blog, most recently deleted first
+	ListTrash(ctx context.Context, fn func(*blogItem) error) error
+	// Restore takes a blog out of the trash, or fails with errNotFound if
+	// it is not in there
+	Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error)
+	// Purge permanently removes the blogs trashed before cutoff, together
+	// with their comments and revisions, and returns how many it removed
+	Purge(ctx context.Context, cutoff time.Time) (int, error)
+
+	// SetStatus moves a blog from status from to status to and sets its
+	// publish time, as one atomic step. It does not bump the version or
+	// record a revision, since the content stays the same.
+	SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error)
+	// PublishDue publishes every scheduled blog whose publish time is not
+	// after now and returns them
+	PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error)
+
+	CommentStore
+	AuthorStore
+	AttachmentStore
+	ReactionStore
+
+	// ListRevisions calls fn for every revision of a blog, oldest first
+	ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error
+	GetRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error)
+}
+
+// CommentStore persists the comments on blogs, next to the blogs themselves
+type CommentStore interface {
+	// AddComment fails with errNotFound if the blog does not exist and
+	// with errCommentNotFound if the parent is not a comment on that blog
+	AddComment(ctx context.Context, comment *commentItem) (*commentItem, error)
+	// ListComments calls fn for every comment on a blog, oldest first
+	ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error
+	// DeleteComment removes a comment along with all replies below it and
+	// returns how many comments were removed
+	DeleteComment(ctx context.Context, id primitive.ObjectID) (int, error)
+}
+
+// AuthorStore persists the profiles of the authors blogs are written by
+type AuthorStore interface {
+	CreateAuthor(ctx context.Context, author *authorItem) (*authorItem, error)
+	GetAuthor(ctx context.Context, id primitive.ObjectID) (*authorItem, error)
+	// UpdateAuthor overwrites the profile fields and keeps CreatedAt
+	UpdateAuthor(ctx context.Context, author *authorItem) (*authorItem, error)
+	// ListAuthors calls fn for every author, oldest first
+	ListAuthors(ctx context.Context, fn func(*authorItem) error) error
+}
+
+// AttachmentStore persists what is known about the files attached to
+// blogs. Their content lives in a blobStore. Purging a blog removes its
+// attachments too.
+type AttachmentStore interface {
+	// CreateAttachment stores an attachment under the id it already has,
+	// which names its blob, or fails with errNotFound if the blog does
+	// not exist
+	CreateAttachment(ctx context.Context, attachment *attachmentItem) (*attachmentItem, error)
+	GetAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error)
+}
+
+// ReactionStore persists the reactions and views of blogs. The counts on
+// a blog are changed with atomic increments, so concurrent requests never
+// lose one, and like SetStatus they do not bump the version or record a
+// revision. Trashed blogs fail with errNotFound.
+type ReactionStore interface {
+	// React sets the reaction of user on a blog, replacing the one they
+	// had, and returns the blog with its new counts
+	React(ctx context.Context, blogID primitive.ObjectID, user, reaction string) (*blogItem, error)
+	// Unreact takes back the reaction of user, or fails with
+	// errReactionNotFound if they have none
+	Unreact(ctx context.Context, blogID primitive.ObjectID, user string) (*blogItem, error)
+	// RecordView counts a view of a blog by viewer unless a view of theirs
+	// was counted less than window ago, and reports whether it counted
+	RecordView(ctx context.Context, blogID primitive.ObjectID, viewer string, window time.Duration) (*blogItem, bool, error)
+}
+
+// blogFields are the fields of a blog that its author writes, named the
+// same in the Blog message and in the store
+var blogFields = []string{"author_id", "title", "content", "tags", "category"}
+
+// withFields returns a copy of old with the fields listed in fields, all
+// of blogFields if nil, taken from item
+func (old *blogItem) withFields(item *blogItem, fields []string) *blogItem {
+	if fields == nil {
+		fields = blogFields
+	}
+	changed := *old
+	for _, f := range fields {
+		switch f {
+		case "author_id":
+			changed.AuthorID = item.AuthorID
+		case "title":
+			changed.Title = item.Title
+		case "content":
+			changed.Content = item.Content
+		case "tags":
+			changed.Tags = item.Tags
+		case "category":
+			changed.Category = item.Category
+		}
+	}
+	return &changed
+}
+
+// listOptions narrows down a BlogStore.List call
+type listOptions struct {
+	After      primitive.ObjectID // exclusive starting point, zero for none
+	Descending bool
+	Limit      int // 0 for no limit
+	// ByPublishTime orders by publish time rather than creation, with ids
+	// breaking ties. Blogs from before drafts count as published when they
+	// were created. After is not used with it.
+	ByPublishTime bool
+
+	// filters, zero values match every blog
+	Tags         []string
+	MatchAllTags bool // require every tag in Tags rather than any of them
+	Category     string
+	AuthorID     string
+	Statuses     []string // any of these statuses
+
+	// Fields, when set, are the only stored fields the caller needs. A
+	// store may leave the others empty; ids always come back.
+	Fields []string
+}
+
+// matches reports whether item passes the filters of opts
+func (opts listOptions) matches(item *blogItem) bool {
+	if opts.Category != "" && item.Category != opts.Category {
+		return false
+	}
+	if opts.AuthorID != "" && item.AuthorID != opts.AuthorID {
+		return false
+	}
+	if len(opts.Statuses) > 0 && !containsString(opts.Statuses, item.status()) {
+		return false
+	}
+	if len(opts.Tags) == 0 {
+		return true
+	}
+
+	has := make(map[string]bool, len(item.Tags))
+	for _, t := range item.Tags {
+		has[t] = true
+	}
+	for _, t := range opts.Tags {
+		if has[t] && !opts.MatchAllTags {
+			return true
+		}
+		if !has[t] && opts.MatchAllTags {
+			return false
+		}
+	}
+	return opts.MatchAllTags
+}
+
+// tagCount is the number of blogs using a tag
+type tagCount struct {
+	Tag   string `bson:"_id"`
+	Count int64  `bson:"count"`
+}
+
+// storeConfig holds the startup flags that select and configure a BlogStore
+type storeConfig struct {
+	Kind         string
+	MongoURI     string
+	DataFile     string
+	CompactEvery time.Duration
+	// MigrateDryRun prints the pending Mongo migrations instead of
+	// applying them
+	MigrateDryRun bool
+}
+
+// openStore builds the BlogStore selected by cfg for tenant t, in the
+// database or file t maps to. The returned func releases any resources it
+// holds and must be called on shutdown.
+func openStore(cfg storeConfig, t tenantConfig) (BlogStore, func(), error) {
+	switch cfg.Kind {
+	case "mongo":
+		return newMongoStore(cfg.MongoURI, t.Database, t.CollectionPrefix, cfg.MigrateDryRun)
+	case "memory":
+		return newMemoryStore(), func() {}, nil
+	case "file":
+		fs, err := newFileStore(t.DataFile, cfg.CompactEvery)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fs, fs.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown store %q, expected mongo, memory or file", cfg.Kind)
+	}
+}
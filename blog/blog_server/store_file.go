@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fileStore is a memoryStore whose mutations are appended to a single log
+// file before they are applied, so small deployments can keep their blogs
+// without running MongoDB.
+//
+// Each record in the log is framed as
+//
+//	[4 byte length][4 byte CRC-32C of payload][BSON encoded mutation]
+//
+// and fsynced before the write is acknowledged. On startup the log is
+// replayed to rebuild the in-memory index; a torn or corrupt last record
+// (left by a crash mid-write) is cut off, leaving every earlier record
+// intact. Damage before the last record is not something a crash leaves,
+// so the store refuses to open the log rather than drop the records after
+// it. Compaction rewrites the live state into a
+// temporary file and renames it over the log, so a crash at any point
+// leaves either the old or the new log behind, never a mix.
+type fileStore struct {
+	*memoryStore
+
+	path string
+	// the fields below are guarded by memoryStore.mu
+	f       *os.File
+	size    int64 // offset of the end of the last good record
+	records int   // records in the log, live or not
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+const (
+	recordHeaderSize = 8
+	// maxRecordSize guards replay against allocating a huge buffer
+	// because of a corrupt length field
+	maxRecordSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt log record")
+
+// newFileStore opens (or creates) the log at path, replays it and starts
+// compacting it every compactEvery. A zero compactEvery disables
+// periodic compaction.
+func newFileStore(path string, compactEvery time.Duration) (*fileStore, error) {
+	// a leftover from a compaction that did not finish; the log itself
+	// is still complete
+	os.Remove(path + ".compact")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &fileStore{
+		memoryStore: newMemoryStore(),
+		path:        path,
+		f:           f,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := fs.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	fs.memoryStore.persist = fs.append
+
+	go fs.compactLoop(compactEvery)
+	return fs, nil
+}
+
+// load replays the log into the memory store and truncates a damaged
+// last record
+func (fs *fileStore) load() error {
+	fi, err := fs.f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := fs.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := make([]byte, recordHeaderSize)
+	for {
+		mut, n, err := readRecord(fs.f, header)
+		if err == io.EOF {
+			break
+		}
+		if err == errCorruptRecord {
+			// a record that ends at the end of the log is the last one,
+			// whatever it holds; one with a length past the limit says
+			// nothing about where it ends
+			length := int64(binary.LittleEndian.Uint32(header[0:4]))
+			last := length <= maxRecordSize && fs.size+recordHeaderSize+length >= fi.Size()
+			if !last && !fs.zeroedFrom(fs.size) {
+				return fmt.Errorf("%v is damaged at offset %v, before its last record; restore it from a backup or move it aside to start empty", fs.path, fs.size)
+			}
+		}
+		if err == io.ErrUnexpectedEOF || err == errCorruptRecord {
+			fmt.Printf("Discarding damaged last record at offset %v in %v\n", fs.size, fs.path)
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fs.memoryStore.replay(mut)
+		fs.size += n
+		fs.records++
+	}
+
+	if err := fs.f.Truncate(fs.size); err != nil {
+		return err
+	}
+	_, err = fs.f.Seek(fs.size, io.SeekStart)
+	return err
+}
+
+// zeroedFrom reports whether the log holds only zeros from offset on, as
+// some file systems leave after a crash while the log was growing
+func (fs *fileStore) zeroedFrom(offset int64) bool {
+	r := bufio.NewReader(io.NewSectionReader(fs.f, offset, maxRecordSize))
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err == io.EOF
+		}
+		if b != 0 {
+			return false
+		}
+	}
+}
+
+// readRecord reads one framed record and returns it along with the number
+// of bytes it took up in the log
+func readRecord(r io.Reader, header []byte) (mutation, int64, error) {
+	mut := mutation{}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return mut, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	sum := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return mut, 0, errCorruptRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return mut, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != sum {
+		return mut, 0, errCorruptRecord
+	}
+	if err := bson.Unmarshal(payload, &mut); err != nil {
+		return mut, 0, errCorruptRecord
+	}
+	return mut, int64(recordHeaderSize + len(payload)), nil
+}
+
+func encodeRecord(mut mutation) ([]byte, error) {
+	payload, err := bson.Marshal(mut)
+	if err != nil {
+		return nil, err
+	}
+	rec := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.Checksum(payload, crcTable))
+	copy(rec[recordHeaderSize:], payload)
+	return rec, nil
+}
+
+// append writes mut to the end of the log and syncs it to disk. It is the
+// memory store's persist hook, so memoryStore.mu is held.
+func (fs *fileStore) append(mut mutation) error {
+	rec, err := encodeRecord(mut)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fs.f.Write(rec); err != nil {
+		fs.rollback()
+		return err
+	}
+	if err := fs.f.Sync(); err != nil {
+		fs.rollback()
+		return err
+	}
+	fs.size += int64(len(rec))
+	fs.records++
+	return nil
+}
+
+// rollback cuts a partially written record off the end of the log so that
+// later appends are not hidden behind it on the next replay
+func (fs *fileStore) rollback() {
+	fs.f.Truncate(fs.size)
+	fs.f.Seek(fs.size, io.SeekStart)
+}
+
+func (fs *fileStore) compactLoop(every time.Duration) {
+	defer close(fs.done)
+	if every <= 0 {
+		<-fs.stop
+		return
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := fs.compact(); err != nil {
+				fmt.Printf("Compacting %v failed: %v\n", fs.path, err)
+			}
+		case <-fs.stop:
+			return
+		}
+	}
+}
+
+// compact rewrites the log so it holds only the live state. It blocks
+// writers while it runs.
+func (fs *fileStore) compact() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	muts := fs.memoryStore.snapshot()
+	if fs.records <= len(muts) {
+		// nothing to reclaim
+		return nil
+	}
+
+	tmpPath := fs.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	size := int64(0)
+	for _, mut := range muts {
+		rec, err := encodeRecord(mut)
+		if err == nil {
+			_, err = tmp.Write(rec)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		size += int64(len(rec))
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	tmp.Close()
+
+	// the old handle has to be closed before the rename on Windows
+	fs.f.Close()
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		os.Remove(tmpPath)
+		if reopenErr := fs.reopen(); reopenErr != nil {
+			return reopenErr
+		}
+		return err
+	}
+	syncDir(filepath.Dir(fs.path))
+
+	fmt.Printf("Compacted %v from %v to %v records\n", fs.path, fs.records, len(muts))
+	fs.records = len(muts)
+	fs.size = size
+	return fs.reopen()
+}
+
+// reopen opens the log for appending after compaction swapped it out
+func (fs *fileStore) reopen() error {
+	f, err := os.OpenFile(fs.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.f = f
+	fs.size = fi.Size()
+	_, err = f.Seek(fs.size, io.SeekStart)
+	return err
+}
+
+// syncDir makes a rename in dir durable. Not every platform can sync a
+// directory, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// Close stops compaction and closes the log
+func (fs *fileStore) Close() {
+	close(fs.stop)
+	<-fs.done
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.f.Close()
+}
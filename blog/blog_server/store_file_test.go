@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// writeLog makes a log at path holding one record for each of titles and
+// returns the blog ids along with the offset each record ends at
+func writeLog(t *testing.T, path string, titles ...string) ([]primitive.ObjectID, []int64) {
+	fs, err := newFileStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var ids []primitive.ObjectID
+	var ends []int64
+	for _, title := range titles {
+		item, err := fs.Create(context.Background(), &blogItem{Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.ID)
+		ends = append(ends, fs.size)
+	}
+	return ids, ends
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(f *os.File, ends []int64) error
+		blogs  int // that survive, 0 if the log cannot be opened
+	}{
+		{"intact", func(f *os.File, ends []int64) error { return nil }, 3},
+		{"truncated in a header", func(f *os.File, ends []int64) error {
+			return f.Truncate(ends[1] + 3)
+		}, 2},
+		{"truncated in a payload", func(f *os.File, ends []int64) error {
+			return f.Truncate(ends[2] - 5)
+		}, 2},
+		{"flipped CRC", func(f *os.File, ends []int64) error {
+			return flipByte(f, ends[1]+4)
+		}, 2},
+		{"flipped payload", func(f *os.File, ends []int64) error {
+			return flipByte(f, ends[1]+recordHeaderSize+10)
+		}, 2},
+		{"zeroed tail", func(f *os.File, ends []int64) error {
+			_, err := f.WriteAt(make([]byte, 100), ends[2])
+			return err
+		}, 3},
+		{"huge length", func(f *os.File, ends []int64) error {
+			_, err := f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, ends[1])
+			return err
+		}, 0},
+		{"flipped CRC in the middle", func(f *os.File, ends []int64) error {
+			return flipByte(f, ends[0]+4)
+		}, 0},
+		{"flipped payload in the middle", func(f *os.File, ends []int64) error {
+			return flipByte(f, recordHeaderSize+10)
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "blogs.log")
+			ids, ends := writeLog(t, path, "one", "two", "three")
+
+			f, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.damage(f, ends)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			damaged, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs, err := newFileStore(path, 0)
+			if tt.blogs == 0 {
+				if err == nil {
+					fs.Close()
+					t.Fatal("a log damaged before its last record was opened")
+				}
+				if after, _ := ioutil.ReadFile(path); !bytes.Equal(after, damaged) {
+					t.Error("the log was changed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, id := range ids {
+				_, err := fs.Get(ctx, id)
+				if found := err == nil; found != (i < tt.blogs) {
+					t.Errorf("blog %v found: %v, want %v", i, found, i < tt.blogs)
+				}
+			}
+			if fi, err := os.Stat(path); err != nil || fi.Size() != ends[tt.blogs-1] {
+				t.Errorf("the log was not cut back to its last good record at %v", ends[tt.blogs-1])
+			}
+
+			// a write after the damage is cut off must survive the next replay
+			added, err := fs.Create(ctx, &blogItem{Title: "four"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs.Close()
+			fs, err = newFileStore(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+			if _, err := fs.Get(ctx, added.ID); err != nil {
+				t.Errorf("the blog written after the damage is lost: %v", err)
+			}
+		})
+	}
+}
+
+func flipByte(f *os.File, offset int64) error {
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		return err
+	}
+	b[0] ^= 0xff
+	_, err := f.WriteAt(b, offset)
+	return err
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "blogs.log")
+	fs, err := newFileStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, _ := fs.Create(ctx, &blogItem{Title: "kept"})
+	for i := 0; i < 5; i++ {
+		item, err = fs.Update(ctx, &blogItem{ID: item.ID, Title: "kept", Content: "edit", Version: item.Version}, []string{"content"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a purged blog leaves only dead records behind
+	gone, _ := fs.Create(ctx, &blogItem{Title: "gone"})
+	if _, err := fs.Delete(ctx, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := fs.Purge(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("purged %v blogs, %v", n, err)
+	}
+
+	before := fs.size
+	if err := fs.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.size >= before {
+		t.Errorf("compaction left %v bytes of %v", fs.size, before)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != fs.size {
+		t.Errorf("the log does not end where the store appends")
+	}
+
+	// appends after compaction go to the new log
+	other, err := fs.Create(ctx, &blogItem{Title: "after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	fs, err = newFileStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	got, err := fs.Get(ctx, item.ID)
+	if err != nil || got.Version != item.Version || got.Content != "edit" {
+		t.Errorf("replayed %+v, %v, want version %v", got, err, item.Version)
+	}
+	revs := 0
+	fs.ListRevisions(ctx, item.ID, func(*revisionItem) error {
+		revs++
+		return nil
+	})
+	if revs != 6 {
+		t.Errorf("replayed %v revisions, want 6", revs)
+	}
+	if _, err := fs.Get(ctx, other.ID); err != nil {
+		t.Errorf("the blog written after compaction is lost: %v", err)
+	}
+	if _, err := fs.Get(ctx, gone.ID); err != errNotFound {
+		t.Errorf("the purged blog is back: %v", err)
+	}
+}
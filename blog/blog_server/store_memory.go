@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps blogs in a map and loses them on restart. It lets the
+// service run in CI and on laptops without a MongoDB instance.
+//
+// Stored items are never modified in place: every write swaps in a fresh
+// copy, so callers may hold on to what they were given.
+type memoryStore struct {
+	mu       sync.RWMutex
+	items    map[primitive.ObjectID]*blogItem
+	comments map[primitive.ObjectID]*commentItem
+	// revisions of each blog, in version order
+	revisions map[primitive.ObjectID][]*revisionItem
+	// tagCounts is kept up to date by replay for the published blogs
+	// outside the trash
+	tagCounts map[string]int64
+	authors   map[primitive.ObjectID]*authorItem
+	// keys holds the idempotency keys by key, expired ones until the next
+	// CreateOnce sweeps them out
+	keys        map[string]*idempotencyItem
+	attachments map[primitive.ObjectID]*attachmentItem
+	// slugs maps every current and old slug to its blog
+	slugs map[string]primitive.ObjectID
+	// reactions holds the reaction of each user on each blog by reactionID
+	reactions map[string]*reactionItem
+	// views holds when a view by each viewer of each blog was last counted.
+	// They are not persisted, so after a restart a repeat view may count
+	// once more.
+	views      map[string]time.Time
+	viewsSwept time.Time
+
+	// persist, when set, is called with mu held before a mutation is
+	// applied. If it fails the mutation is dropped and the write fails.
+	persist func(mutation) error
+}
+
+// mutation is a single change to a memoryStore. Every write goes through
+// apply, which lets the file store log and replay them.
+type mutation struct {
+	Op      string             `bson:"op"`
+	Blog    *blogItem          `bson:"blog,omitempty"`
+	Comment *commentItem       `bson:"comment,omitempty"`
+	ID      primitive.ObjectID `bson:"id,omitempty"`
+	// Revision goes with opPutBlog, so a write and its revision are
+	// logged as one record
+	Revision *revisionItem `bson:"revision,omitempty"`
+	Author   *authorItem   `bson:"author,omitempty"`
+	// Key goes with opPutBlog for a blog created by CreateOnce, and on
+	// its own with opPutKey
+	Key        *idempotencyItem `bson:"key,omitempty"`
+	Attachment *attachmentItem  `bson:"attachment,omitempty"`
+	// Reaction goes with opPutBlog for a blog whose reaction counts
+	// changed, where an empty Reaction.Reaction removes it, and on its own
+	// with opPutReaction
+	Reaction *reactionItem `bson:"reaction,omitempty"`
+}
+
+const (
+	opPutBlog       = "put_blog"
+	opDeleteBlog    = "delete_blog"
+	opPutComment    = "put_comment"
+	opDeleteComment = "delete_comment"
+	opPutRevision   = "put_revision"
+	opPutAuthor     = "put_author"
+	opPutKey        = "put_key"
+	opPutAttachment = "put_attachment"
+	opPutReaction   = "put_reaction"
+	// opAddView counts a view of the blog with ID. Views are the most
+	// frequent write, so they are logged without the blog.
+	opAddView = "add_view"
+)
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		items:       make(map[primitive.ObjectID]*blogItem),
+		comments:    make(map[primitive.ObjectID]*commentItem),
+		revisions:   make(map[primitive.ObjectID][]*revisionItem),
+		tagCounts:   make(map[string]int64),
+		authors:     make(map[primitive.ObjectID]*authorItem),
+		keys:        make(map[string]*idempotencyItem),
+		attachments: make(map[primitive.ObjectID]*attachmentItem),
+		slugs:       make(map[string]primitive.ObjectID),
+		reactions:   make(map[string]*reactionItem),
+		views:       make(map[string]time.Time),
+	}
+}
+
+// apply persists and then applies mut. The caller must hold m.mu.
+func (m *memoryStore) apply(mut mutation) error {
+	if m.persist != nil {
+		if err := m.persist(mut); err != nil {
+			return err
+		}
+	}
+	m.replay(mut)
+	return nil
+}
+
+// replay applies mut without persisting it. The caller must hold m.mu.
+func (m *memoryStore) replay(mut mutation) {
+	switch mut.Op {
+	case opPutBlog:
+		if old, ok := m.items[mut.Blog.ID]; ok {
+			m.countTags(old, -1)
+		}
+		m.items[mut.Blog.ID] = mut.Blog
+		m.countTags(mut.Blog, 1)
+		for _, slug := range mut.Blog.Slugs {
+			m.slugs[slug] = mut.Blog.ID
+		}
+		if mut.Revision != nil {
+			m.revisions[mut.Blog.ID] = append(m.revisions[mut.Blog.ID], mut.Revision)
+		}
+		if mut.Key != nil {
+			m.keys[mut.Key.Key] = mut.Key
+		}
+		if mut.Reaction != nil {
+			m.putReaction(mut.Reaction)
+		}
+	case opPutReaction:
+		m.putReaction(mut.Reaction)
+	case opAddView:
+		if item, ok := m.items[mut.ID]; ok {
+			viewed := *item
+			viewed.Views++
+			m.items[mut.ID] = &viewed
+		}
+	case opPutKey:
+		m.keys[mut.Key.Key] = mut.Key
+	case opPutAttachment:
+		m.attachments[mut.Attachment.ID] = mut.Attachment
+	case opPutRevision:
+		m.revisions[mut.Revision.BlogID] = append(m.revisions[mut.Revision.BlogID], mut.Revision)
+	case opDeleteBlog:
+		if old, ok := m.items[mut.ID]; ok {
+			m.countTags(old, -1)
+			for _, slug := range old.Slugs {
+				delete(m.slugs, slug)
+			}
+		}
+		delete(m.items, mut.ID)
+		delete(m.revisions, mut.ID)
+		for id, c := range m.comments {
+			if c.BlogID == mut.ID {
+				delete(m.comments, id)
+			}
+		}
+		for id, a := range m.attachments {
+			if a.BlogID == mut.ID {
+				delete(m.attachments, id)
+			}
+		}
+		for id, r := range m.reactions {
+			if r.BlogID == mut.ID {
+				delete(m.reactions, id)
+			}
+		}
+	case opPutAuthor:
+		m.authors[mut.Author.ID] = mut.Author
+	case opPutComment:
+		m.comments[mut.Comment.ID] = mut.Comment
+	case opDeleteComment:
+		for _, id := range m.commentThread(mut.ID) {
+			delete(m.comments, id)
+		}
+	}
+}
+
+// putReaction stores r, or removes the reaction it is for if it has none.
+// The caller must hold m.mu.
+func (m *memoryStore) putReaction(r *reactionItem) {
+	if r.Reaction == "" {
+		delete(m.reactions, r.ID)
+	} else {
+		m.reactions[r.ID] = r
+	}
+}
+
+// countTags adds delta to the count of every tag on item, unless it is in
+// the trash or not published. The caller must hold m.mu.
+func (m *memoryStore) countTags(item *blogItem, delta int64) {
+	for _, t := range item.countedTags() {
+		m.tagCounts[t] += delta
+		if m.tagCounts[t] <= 0 {
+			delete(m.tagCounts, t)
+		}
+	}
+}
+
+// freeSlug returns the first slug for base that no blog but id has or had.
+// The caller must hold m.mu.
+func (m *memoryStore) freeSlug(base string, id primitive.ObjectID) string {
+	for n := 1; ; n++ {
+		slug := slugCandidate(base, n)
+		if owner, ok := m.slugs[slug]; !ok || owner == id {
+			return slug
+		}
+	}
+}
+
+// commentThread returns the id of a comment followed by the ids of every
+// reply below it. The caller must hold m.mu.
+func (m *memoryStore) commentThread(id primitive.ObjectID) []primitive.ObjectID {
+	thread := []primitive.ObjectID{id}
+	for i := 0; i < len(thread); i++ {
+		for cid, c := range m.comments {
+			if c.ParentID == thread[i] {
+				thread = append(thread, cid)
+			}
+		}
+	}
+	return thread
+}
+
+// snapshot returns the mutations that rebuild the current state from
+// scratch. The caller must hold m.mu.
+func (m *memoryStore) snapshot() []mutation {
+	muts := make([]mutation, 0, len(m.items)+len(m.comments))
+	for _, item := range m.items {
+		muts = append(muts, mutation{Op: opPutBlog, Blog: item})
+	}
+	for _, revs := range m.revisions {
+		for _, rev := range revs {
+			muts = append(muts, mutation{Op: opPutRevision, Revision: rev})
+		}
+	}
+	for _, c := range m.comments {
+		muts = append(muts, mutation{Op: opPutComment, Comment: c})
+	}
+	for _, a := range m.authors {
+		muts = append(muts, mutation{Op: opPutAuthor, Author: a})
+	}
+	for _, a := range m.attachments {
+		muts = append(muts, mutation{Op: opPutAttachment, Attachment: a})
+	}
+	for _, r := range m.reactions {
+		muts = append(muts, mutation{Op: opPutReaction, Reaction: r})
+	}
+	now := time.Now()
+	for _, key := range m.keys {
+		if !key.expired(now) {
+			muts = append(muts, mutation{Op: opPutKey, Key: key})
+		}
+	}
+	return muts
+}
+
+func (m *memoryStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	created := *item
+	created.ID = primitive.NewObjectID()
+	created.Version = 1
+	created.CreatedAt = time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	created.Slugs = []string{m.freeSlug(titleSlug(created.Title), created.ID)}
+	if err := m.apply(mutation{Op: opPutBlog, Blog: &created, Revision: newRevision(&created)}); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *memoryStore) CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if prior, ok := m.keys[key.Key]; ok && !prior.expired(now) {
+		return nil, prior, nil
+	}
+	for k, prior := range m.keys {
+		if prior.expired(now) {
+			delete(m.keys, k)
+		}
+	}
+
+	created := *item
+	created.ID = primitive.NewObjectID()
+	created.Version = 1
+	created.CreatedAt = time.Now().UTC()
+	created.Slugs = []string{m.freeSlug(titleSlug(created.Title), created.ID)}
+	stored := *key
+	stored.Blog = &created
+	mut := mutation{Op: opPutBlog, Blog: &created, Revision: newRevision(&created), Key: &stored}
+	if err := m.apply(mut); err != nil {
+		return nil, nil, err
+	}
+	return &created, nil, nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	return item, nil
+}
+
+func (m *memoryStore) GetBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.items[m.slugs[slug]]
+	if !ok || item.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	return item, nil
+}
+
+func (m *memoryStore) Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.items[item.ID]
+	if !ok || old.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	if old.Version != item.Version {
+		return nil, errVersionConflict
+	}
+
+	updated := old.withFields(item, fields)
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	if needsSlug(old, updated) {
+		updated.Slugs = withSlug(old.Slugs, m.freeSlug(titleSlug(updated.Title), old.ID))
+	}
+	if err := m.apply(mutation{Op: opPutBlog, Blog: updated, Revision: newRevision(updated)}); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt != nil {
+		return nil, errNotFound
+	}
+
+	trashed := *item
+	now := time.Now().UTC()
+	trashed.DeletedAt = &now
+	if err := m.apply(mutation{Op: opPutBlog, Blog: &trashed}); err != nil {
+		return nil, err
+	}
+	return &trashed, nil
+}
+
+func (m *memoryStore) SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	if item.status() != from {
+		return nil, errStatusConflict
+	}
+
+	changed := *item
+	changed.Status = to
+	changed.PublishAt = publishAt
+	if err := m.apply(mutation{Op: opPutBlog, Blog: &changed}); err != nil {
+		return nil, err
+	}
+	return &changed, nil
+}
+
+func (m *memoryStore) PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var published []*blogItem
+	for _, item := range m.items {
+		if item.DeletedAt != nil || item.Status != statusScheduled || (item.PublishAt != nil && item.PublishAt.After(now)) {
+			continue
+		}
+		changed := *item
+		changed.Status = statusPublished
+		if err := m.apply(mutation{Op: opPutBlog, Blog: &changed}); err != nil {
+			return published, err
+		}
+		published = append(published, &changed)
+	}
+	return published, nil
+}
+
+func (m *memoryStore) ListTags(ctx context.Context) ([]tagCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tags := make([]tagCount, 0, len(m.tagCounts))
+	for t, n := range m.tagCounts {
+		tags = append(tags, tagCount{Tag: t, Count: n})
+	}
+	return tags, nil
+}
+
+func (m *memoryStore) ListTrash(ctx context.Context, fn func(*blogItem) error) error {
+	m.mu.RLock()
+	var trash []*blogItem
+	for _, item := range m.items {
+		if item.DeletedAt != nil {
+			trash = append(trash, item)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.After(*trash[j].DeletedAt)
+	})
+	for _, item := range trash {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt == nil {
+		return nil, errNotFound
+	}
+
+	restored := *item
+	restored.DeletedAt = nil
+	if err := m.apply(mutation{Op: opPutBlog, Blog: &restored}); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+func (m *memoryStore) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, item := range m.items {
+		if item.DeletedAt == nil || !item.DeletedAt.Before(cutoff) {
+			continue
+		}
+		if err := m.apply(mutation{Op: opDeleteBlog, ID: id}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (m *memoryStore) List(ctx context.Context, opts listOptions, fn func(*blogItem) error) error {
+	// take a snapshot so fn (usually a stream send) runs without the lock
+	m.mu.RLock()
+	page := make([]*blogItem, 0, len(m.items))
+	for _, item := range m.items {
+		if item.DeletedAt != nil || !opts.matches(item) {
+			continue
+		}
+		if !opts.After.IsZero() && !opts.ByPublishTime {
+			c := bytes.Compare(item.ID[:], opts.After[:])
+			if (!opts.Descending && c <= 0) || (opts.Descending && c >= 0) {
+				continue
+			}
+		}
+		page = append(page, item)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(page, func(i, j int) bool {
+		c := bytes.Compare(page[i].ID[:], page[j].ID[:])
+		if opts.ByPublishTime {
+			c = comparePublishTimes(page[i].publishedAt(), page[j].publishedAt(), c)
+		}
+		if opts.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+	if opts.Limit > 0 && len(page) > opts.Limit {
+		page = page[:opts.Limit]
+	}
+
+	for _, item := range page {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comparePublishTimes orders publish times, falling back to byID for
+// equal ones
+func comparePublishTimes(a, b time.Time, byID int) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return byID
+}
+
+func (m *memoryStore) AddComment(ctx context.Context, comment *commentItem) (*commentItem, error) {
+	created := *comment
+	created.ID = primitive.NewObjectID()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if blog, ok := m.items[created.BlogID]; !ok || blog.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	if !created.ParentID.IsZero() {
+		parent, ok := m.comments[created.ParentID]
+		if !ok || parent.BlogID != created.BlogID {
+			return nil, errCommentNotFound
+		}
+	}
+	if err := m.apply(mutation{Op: opPutComment, Comment: &created}); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *memoryStore) ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error {
+	m.mu.RLock()
+	var thread []*commentItem
+	for _, c := range m.comments {
+		if c.BlogID == blogID {
+			thread = append(thread, c)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(thread, func(i, j int) bool {
+		return bytes.Compare(thread[i].ID[:], thread[j].ID[:]) < 0
+	})
+	for _, c := range thread {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteComment(ctx context.Context, id primitive.ObjectID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.comments[id]; !ok {
+		return 0, errCommentNotFound
+	}
+	n := len(m.commentThread(id))
+	if err := m.apply(mutation{Op: opDeleteComment, ID: id}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (m *memoryStore) ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error {
+	m.mu.RLock()
+	revs := m.revisions[blogID]
+	m.mu.RUnlock()
+
+	// revisions are only ever appended, so the slice header taken under
+	// the lock stays valid
+	for _, rev := range revs {
+		if err := fn(rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) GetRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, rev := range m.revisions[blogID] {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return nil, errRevisionNotFound
+}
+
+func (m *memoryStore) CreateAuthor(ctx context.Context, author *authorItem) (*authorItem, error) {
+	created := *author
+	created.ID = primitive.NewObjectID()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.apply(mutation{Op: opPutAuthor, Author: &created}); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *memoryStore) GetAuthor(ctx context.Context, id primitive.ObjectID) (*authorItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	author, ok := m.authors[id]
+	if !ok {
+		return nil, errAuthorNotFound
+	}
+	return author, nil
+}
+
+func (m *memoryStore) UpdateAuthor(ctx context.Context, author *authorItem) (*authorItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.authors[author.ID]
+	if !ok {
+		return nil, errAuthorNotFound
+	}
+
+	updated := *author
+	updated.CreatedAt = old.CreatedAt
+	if err := m.apply(mutation{Op: opPutAuthor, Author: &updated}); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (m *memoryStore) ListAuthors(ctx context.Context, fn func(*authorItem) error) error {
+	m.mu.RLock()
+	authors := make([]*authorItem, 0, len(m.authors))
+	for _, a := range m.authors {
+		authors = append(authors, a)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(authors, func(i, j int) bool {
+		return bytes.Compare(authors[i].ID[:], authors[j].ID[:]) < 0
+	})
+	for _, a := range authors {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) CreateAttachment(ctx context.Context, attachment *attachmentItem) (*attachmentItem, error) {
+	created := *attachment
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if blog, ok := m.items[created.BlogID]; !ok || blog.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	if err := m.apply(mutation{Op: opPutAttachment, Attachment: &created}); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *memoryStore) GetAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attachment, ok := m.attachments[id]
+	if !ok {
+		return nil, errAttachmentNotFound
+	}
+	return attachment, nil
+}
+
+func (m *memoryStore) React(ctx context.Context, blogID primitive.ObjectID, user, reaction string) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[blogID]
+	if !ok || item.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	id := reactionID(blogID, user)
+	old := ""
+	if r, ok := m.reactions[id]; ok {
+		old = r.Reaction
+	}
+	if old == reaction {
+		return item, nil
+	}
+
+	changed := item.withReaction(old, reaction)
+	r := &reactionItem{ID: id, BlogID: blogID, UserID: user, Reaction: reaction}
+	if err := m.apply(mutation{Op: opPutBlog, Blog: changed, Reaction: r}); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (m *memoryStore) Unreact(ctx context.Context, blogID primitive.ObjectID, user string) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[blogID]
+	if !ok || item.DeletedAt != nil {
+		return nil, errNotFound
+	}
+	id := reactionID(blogID, user)
+	old, ok := m.reactions[id]
+	if !ok {
+		return nil, errReactionNotFound
+	}
+
+	changed := item.withReaction(old.Reaction, "")
+	r := &reactionItem{ID: id, BlogID: blogID, UserID: user}
+	if err := m.apply(mutation{Op: opPutBlog, Blog: changed, Reaction: r}); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (m *memoryStore) RecordView(ctx context.Context, blogID primitive.ObjectID, viewer string, window time.Duration) (*blogItem, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[blogID]
+	if !ok || item.DeletedAt != nil {
+		return nil, false, errNotFound
+	}
+	id := reactionID(blogID, viewer)
+	now := time.Now()
+	if last, ok := m.views[id]; ok && now.Sub(last) < window {
+		return item, false, nil
+	}
+
+	if err := m.apply(mutation{Op: opAddView, ID: blogID}); err != nil {
+		return nil, false, err
+	}
+	m.views[id] = now
+	// forget the views that no longer hold anything back, at most once
+	// per window
+	if now.Sub(m.viewsSwept) >= window {
+		for id, last := range m.views {
+			if now.Sub(last) >= window {
+				delete(m.views, id)
+			}
+		}
+		m.viewsSwept = now
+	}
+	return m.items[blogID], true, nil
+}
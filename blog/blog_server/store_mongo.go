@@ -0,0 +1,965 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore keeps blogs in the myblogdb.blog collection, their comments
+// in myblogdb.comment and their revisions in myblogdb.revision.
+// myblogdb.tag holds a running count per tag, so listing tags does not have
+// to go through every blog. Authors live in myblogdb.author, and the
+// idempotency keys of CreateBlog in myblogdb.idempotency_key, where a TTL
+// index removes them once they expire. What is known about attachments is
+// in myblogdb.attachment; their content is not kept in Mongo at all.
+// myblogdb.reaction holds the reaction of each user on each blog, and
+// myblogdb.view when a view of each viewer was last counted, until a TTL
+// index removes it; the counts themselves are kept on the blogs. The
+// indexes are set up by the migrations in migrate_mongo.go.
+//
+// Each tenant has a store of its own, in a database and with a prefix on
+// the collection names of its choosing; myblogdb without a prefix is what
+// a server without tenants uses.
+type mongoStore struct {
+	collection  *mongo.Collection
+	comments    *mongo.Collection
+	revisions   *mongo.Collection
+	tags        *mongo.Collection
+	authors     *mongo.Collection
+	keys        *mongo.Collection
+	attachments *mongo.Collection
+	reactions   *mongo.Collection
+	views       *mongo.Collection
+	migrations  *mongo.Collection
+}
+
+// newMongoStore connects to uri and migrates the collections starting
+// with prefix in database. With dryRun it only prints the migrations it
+// would apply.
+func newMongoStore(uri string, database string, prefix string, dryRun bool) (BlogStore, func(), error) {
+	fmt.Printf("Connecting to MongoDB database %v\n", database)
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := client.Connect(context.TODO()); err != nil {
+		return nil, nil, err
+	}
+
+	closeFn := func() {
+		fmt.Println("Closing MongoDB connection")
+		client.Disconnect(context.Background())
+	}
+	db := client.Database(database)
+	m := &mongoStore{
+		collection:  db.Collection(prefix + "blog"),
+		comments:    db.Collection(prefix + "comment"),
+		revisions:   db.Collection(prefix + "revision"),
+		tags:        db.Collection(prefix + "tag"),
+		authors:     db.Collection(prefix + "author"),
+		keys:        db.Collection(prefix + "idempotency_key"),
+		attachments: db.Collection(prefix + "attachment"),
+		reactions:   db.Collection(prefix + "reaction"),
+		views:       db.Collection(prefix + "view"),
+		migrations:  db.Collection(prefix + "schema_migration"),
+	}
+
+	if err := m.migrate(context.TODO(), dryRun); err != nil {
+		closeFn()
+		return nil, nil, err
+	}
+	return m, closeFn, nil
+}
+
+// slugAttempts is how many times a write tries another slug after losing
+// the one it picked to a concurrent write
+const slugAttempts = 3
+
+func (m *mongoStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	created := *item
+	created.Version = 1
+	// Mongo keeps times to the millisecond, so the blog returned is the
+	// blog a later read, or the replay of an idempotency key, gets
+	created.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if created.PublishAt != nil {
+		at := created.PublishAt.Truncate(time.Millisecond)
+		created.PublishAt = &at
+	}
+
+	var res *mongo.InsertOneResult
+	for attempt := 1; ; attempt++ {
+		slug, err := m.freeSlug(ctx, titleSlug(created.Title), created.ID)
+		if err != nil {
+			return nil, err
+		}
+		created.Slugs = []string{slug}
+		res, err = m.collection.InsertOne(ctx, &created)
+		if err == nil {
+			break
+		}
+		if !isDuplicateKey(err) || attempt == slugAttempts {
+			return nil, err
+		}
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v to OID", res.InsertedID)
+	}
+
+	created.ID = oid
+	if err := m.addRevision(ctx, &created); err != nil {
+		return nil, err
+	}
+	if err := m.countTags(ctx, created.countedTags(), nil); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// CreateOnce stores the key as pending before the blog, so of two requests
+// with the same key only the first gets to create a blog, and sets the
+// created blog on the key after. A request coming in between, or after
+// the server stopped in between, finds the key pending and gets the blog
+// if it was written by then.
+func (m *mongoStore) CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error) {
+	created := *item
+	created.ID = primitive.NewObjectID()
+	stored := *key
+	stored.Blog = nil
+	stored.Pending = true
+	stored.BlogID = created.ID
+
+	// the TTL monitor only runs once a minute, so an expired key may
+	// still be around and has to be removed before the key is used again
+	for attempt := 0; ; attempt++ {
+		_, err := m.keys.InsertOne(ctx, &stored)
+		if err == nil {
+			break
+		}
+		if !isDuplicateKey(err) || attempt > 0 {
+			return nil, nil, err
+		}
+
+		prior := &idempotencyItem{}
+		err = m.keys.FindOne(ctx, bson.M{"_id": key.Key}).Decode(prior)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !prior.expired(time.Now()) {
+			if prior.Pending {
+				if err := m.settle(ctx, prior); err != nil {
+					return nil, nil, err
+				}
+			}
+			return nil, prior, nil
+		}
+		if _, err := m.keys.DeleteOne(ctx, bson.M{"_id": key.Key, "expires_at": prior.ExpiresAt}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	blog, err := m.Create(ctx, &created)
+	if err != nil {
+		// let a retry with the same key try again
+		m.keys.DeleteOne(ctx, bson.M{"_id": key.Key})
+		return nil, nil, err
+	}
+	update := bson.M{"$set": bson.M{"blog": blog}, "$unset": bson.M{"pending": ""}}
+	if _, err := m.keys.UpdateOne(ctx, bson.M{"_id": key.Key}, update); err != nil {
+		return nil, nil, err
+	}
+	return blog, nil, nil
+}
+
+// settle reads the blog of a pending key, and records it on the key if it
+// was created by now
+func (m *mongoStore) settle(ctx context.Context, key *idempotencyItem) error {
+	blog, err := m.Get(ctx, key.BlogID)
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key.Blog, key.Pending = blog, false
+	update := bson.M{"$set": bson.M{"blog": blog}, "$unset": bson.M{"pending": ""}}
+	_, err = m.keys.UpdateOne(ctx, bson.M{"_id": key.Key, "pending": true}, update)
+	return err
+}
+
+// isDuplicateKey reports whether err comes from a write that broke a
+// unique index
+func isDuplicateKey(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == 11000 {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		// what FindOneAndUpdate fails with
+		return e.Code == 11000
+	}
+	return false
+}
+
+// countTags moves the tag counts from the tags a blog had before a write
+// to the ones it has after it. Like revisions, the counts are updated
+// right after the write rather than atomically with it.
+func (m *mongoStore) countTags(ctx context.Context, added, removed []string) error {
+	delta := make(map[string]int)
+	for _, t := range added {
+		delta[t]++
+	}
+	for _, t := range removed {
+		delta[t]--
+	}
+
+	decremented := false
+	for t, d := range delta {
+		if d == 0 {
+			continue
+		}
+		opts := options.Update().SetUpsert(true)
+		if _, err := m.tags.UpdateOne(ctx, bson.M{"_id": t}, bson.M{"$inc": bson.M{"count": d}}, opts); err != nil {
+			return fmt.Errorf("cannot update the count of tag %v: %v", t, err)
+		}
+		decremented = decremented || d < 0
+	}
+	if decremented {
+		_, err := m.tags.DeleteMany(ctx, bson.M{"count": bson.M{"$lte": 0}})
+		return err
+	}
+	return nil
+}
+
+// freeSlug returns the first slug for base that no blog but id has or had.
+// A concurrent write can still take it first, which the unique index turns
+// into a duplicate key error.
+func (m *mongoStore) freeSlug(ctx context.Context, base string, id primitive.ObjectID) (string, error) {
+	// base only holds letters, digits and dashes, nothing a regex treats
+	// specially
+	filter := bson.M{
+		"_id":   bson.M{"$ne": id},
+		"slugs": primitive.Regex{Pattern: "^" + base + "(-[0-9]+)?$"},
+	}
+	cur, err := m.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"slugs": 1}))
+	if err != nil {
+		return "", err
+	}
+	var taken []blogItem
+	if err := cur.All(ctx, &taken); err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool)
+	for _, item := range taken {
+		for _, slug := range item.Slugs {
+			used[slug] = true
+		}
+	}
+	for n := 1; ; n++ {
+		if slug := slugCandidate(base, n); !used[slug] {
+			return slug, nil
+		}
+	}
+}
+
+// addRevision records the revision for a write that just went through.
+// Without multi-document transactions (which need a replica set) the two
+// inserts are not atomic; a failure here is reported but leaves the write
+// in place.
+func (m *mongoStore) addRevision(ctx context.Context, item *blogItem) error {
+	if _, err := m.revisions.InsertOne(ctx, newRevision(item)); err != nil {
+		return fmt.Errorf("blog %v was written but its revision was not: %v", item.ID.Hex(), err)
+	}
+	return nil
+}
+
+// live matches the blogs that are not in the trash
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// trashed matches the blogs that are in the trash
+func trashed(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": true}
+	return filter
+}
+
+func (m *mongoStore) Get(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	data := &blogItem{}
+	if err := m.collection.FindOne(ctx, live(bson.M{"_id": id})).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) GetBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	data := &blogItem{}
+	if err := m.collection.FindOne(ctx, live(bson.M{"slugs": slug})).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error) {
+	// the slugs come from the blog as it is now; if it changes before the
+	// update below, the version no longer matches and the update fails
+	current, err := m.Get(ctx, item.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// matching on the version makes the check and the write a single
+	// atomic operation; blogs written before versioning have none
+	filter := live(bson.M{"_id": item.ID, "version": item.Version})
+	if item.Version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	// only the fields being written go in the $set, so the others keep
+	// whatever they have in the store
+	merged := current.withFields(item, fields)
+	if fields == nil {
+		fields = blogFields
+	}
+	set := bson.M{}
+	for _, f := range fields {
+		switch f {
+		case "author_id":
+			set[f] = item.AuthorID
+		case "title":
+			set[f] = item.Title
+		case "content":
+			set[f] = item.Content
+		case "tags":
+			set[f] = item.Tags
+		case "category":
+			set[f] = item.Category
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	set["updated_at"] = now
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	// the old document is needed to move the tag counts
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	slugs := current.Slugs
+	old := &blogItem{}
+	for attempt := 1; ; attempt++ {
+		if needsSlug(current, merged) {
+			slug, err := m.freeSlug(ctx, titleSlug(merged.Title), item.ID)
+			if err != nil {
+				return nil, err
+			}
+			slugs = withSlug(current.Slugs, slug)
+			set["slugs"] = slugs
+		}
+		err := m.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(old)
+		if err == nil {
+			break
+		}
+		if err == mongo.ErrNoDocuments {
+			return nil, m.missOrConflict(ctx, item.ID)
+		}
+		if !isDuplicateKey(err) || attempt == slugAttempts {
+			return nil, err
+		}
+	}
+
+	data := old.withFields(item, fields)
+	data.Slugs = slugs
+	data.Version++
+	data.UpdatedAt = now
+	if err := m.addRevision(ctx, data); err != nil {
+		return nil, err
+	}
+	if err := m.countTags(ctx, data.countedTags(), old.countedTags()); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// missOrConflict tells apart the two reasons a versioned write can match
+// nothing: the blog is gone, or it moved on to another version
+func (m *mongoStore) missOrConflict(ctx context.Context, id primitive.ObjectID) error {
+	err := m.collection.FindOne(ctx, live(bson.M{"_id": id})).Err()
+	if err == mongo.ErrNoDocuments {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return errVersionConflict
+}
+
+func (m *mongoStore) Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	now := time.Now().UTC()
+	update := bson.M{"$set": bson.M{"deleted_at": now}}
+
+	data := &blogItem{}
+	if err := m.collection.FindOneAndUpdate(ctx, live(bson.M{"_id": id}), update).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	// blogs in the trash do not count towards their tags
+	if err := m.countTags(ctx, nil, data.countedTags()); err != nil {
+		return nil, err
+	}
+	data.DeletedAt = &now
+	return data, nil
+}
+
+func (m *mongoStore) ListTags(ctx context.Context) ([]tagCount, error) {
+	cur, err := m.tags.Find(ctx, bson.M{"count": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	var tags []tagCount
+	if err := cur.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (m *mongoStore) ListTrash(ctx context.Context, fn func(*blogItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}})
+	cur, err := m.collection.Find(ctx, trashed(bson.M{}), opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		data := &blogItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *mongoStore) Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	data := &blogItem{}
+	res := m.collection.FindOneAndUpdate(ctx, trashed(bson.M{"_id": id}), update, opts)
+	if err := res.Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	if err := m.countTags(ctx, data.countedTags(), nil); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// withStatus matches the blogs with one of statuses. Blogs stored before
+// there were statuses have none and count as published.
+func withStatus(filter bson.M, statuses []string) bson.M {
+	in := bson.A{}
+	for _, st := range statuses {
+		in = append(in, st)
+		if st == statusPublished {
+			in = append(in, nil)
+		}
+	}
+	filter["status"] = bson.M{"$in": in}
+	return filter
+}
+
+func (m *mongoStore) SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error) {
+	update := bson.M{"$set": bson.M{"status": to}}
+	if publishAt != nil {
+		update["$set"].(bson.M)["publish_at"] = *publishAt
+	} else {
+		update["$unset"] = bson.M{"publish_at": ""}
+	}
+
+	old := &blogItem{}
+	filter := withStatus(live(bson.M{"_id": id}), []string{from})
+	if err := m.collection.FindOneAndUpdate(ctx, filter, update).Decode(old); err != nil {
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		err = m.collection.FindOne(ctx, live(bson.M{"_id": id})).Err()
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, errStatusConflict
+	}
+
+	data := *old
+	data.Status = to
+	data.PublishAt = publishAt
+	if err := m.countTags(ctx, data.countedTags(), old.countedTags()); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (m *mongoStore) PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error) {
+	due := live(bson.M{"status": statusScheduled, "publish_at": bson.M{"$lte": now}})
+	cur, err := m.collection.Find(ctx, due)
+	if err != nil {
+		return nil, err
+	}
+	var items []blogItem
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	var published []*blogItem
+	update := bson.M{"$set": bson.M{"status": statusPublished}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	for _, item := range items {
+		// the filter is checked again so a blog rescheduled or unpublished
+		// in the meantime is left alone
+		filter := live(bson.M{"_id": item.ID, "status": statusScheduled, "publish_at": bson.M{"$lte": now}})
+		data := &blogItem{}
+		if err := m.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(data); err != nil {
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			return published, err
+		}
+		if err := m.countTags(ctx, data.countedTags(), nil); err != nil {
+			return published, err
+		}
+		published = append(published, data)
+	}
+	return published, nil
+}
+
+func (m *mongoStore) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	cur, err := m.collection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var expired []blogItem
+	if err := cur.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, item := range expired {
+		// the cutoff is checked again so a blog restored in the meantime
+		// keeps its comments and revisions
+		filter := bson.M{"_id": item.ID, "deleted_at": bson.M{"$lt": cutoff}}
+		res, err := m.collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return n, err
+		}
+		if res.DeletedCount == 0 {
+			continue
+		}
+		if _, err := m.comments.DeleteMany(ctx, bson.M{"blog_id": item.ID}); err != nil {
+			return n, err
+		}
+		if _, err := m.revisions.DeleteMany(ctx, bson.M{"blog_id": item.ID}); err != nil {
+			return n, err
+		}
+		if _, err := m.attachments.DeleteMany(ctx, bson.M{"blog_id": item.ID}); err != nil {
+			return n, err
+		}
+		if _, err := m.reactions.DeleteMany(ctx, bson.M{"blog_id": item.ID}); err != nil {
+			return n, err
+		}
+		if _, err := m.views.DeleteMany(ctx, bson.M{"blog_id": item.ID}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (m *mongoStore) List(ctx context.Context, opts listOptions, fn func(*blogItem) error) error {
+	// ObjectIDs start with their creation time, so sorting on _id gives
+	// a stable creation order that a cursor can resume from
+	direction, compare := 1, "$gt"
+	if opts.Descending {
+		direction, compare = -1, "$lt"
+	}
+	filter := live(bson.M{})
+	if !opts.After.IsZero() && !opts.ByPublishTime {
+		filter["_id"] = bson.M{compare: opts.After}
+	}
+	if len(opts.Tags) > 0 {
+		match := "$in"
+		if opts.MatchAllTags {
+			match = "$all"
+		}
+		filter["tags"] = bson.M{match: opts.Tags}
+	}
+	if opts.Category != "" {
+		filter["category"] = opts.Category
+	}
+	if opts.AuthorID != "" {
+		filter["author_id"] = opts.AuthorID
+	}
+	if len(opts.Statuses) > 0 {
+		withStatus(filter, opts.Statuses)
+	}
+	sortBy := bson.D{{Key: "_id", Value: direction}}
+	if opts.ByPublishTime {
+		sortBy = bson.D{{Key: "publish_at", Value: direction}, {Key: "_id", Value: direction}}
+	}
+	findOpts := options.Find().
+		SetSort(sortBy).
+		SetLimit(int64(opts.Limit))
+	if len(opts.Fields) > 0 {
+		projection := bson.M{}
+		for _, f := range opts.Fields {
+			projection[f] = 1
+		}
+		findOpts.SetProjection(projection)
+	}
+
+	cur, err := m.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		data := &blogItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *mongoStore) AddComment(ctx context.Context, comment *commentItem) (*commentItem, error) {
+	if err := m.collection.FindOne(ctx, live(bson.M{"_id": comment.BlogID})).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	if !comment.ParentID.IsZero() {
+		filter := bson.M{"_id": comment.ParentID, "blog_id": comment.BlogID}
+		if err := m.comments.FindOne(ctx, filter).Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errCommentNotFound
+			}
+			return nil, err
+		}
+	}
+
+	res, err := m.comments.InsertOne(ctx, comment)
+	if err != nil {
+		return nil, err
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v to OID", res.InsertedID)
+	}
+
+	created := *comment
+	created.ID = oid
+	return &created, nil
+}
+
+func (m *mongoStore) ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cur, err := m.comments.Find(ctx, bson.M{"blog_id": blogID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		data := &commentItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *mongoStore) DeleteComment(ctx context.Context, id primitive.ObjectID) (int, error) {
+	// walk down the thread one level at a time to collect the replies
+	thread := []primitive.ObjectID{id}
+	level := thread
+	for len(level) > 0 {
+		cur, err := m.comments.Find(ctx, bson.M{"parent_id": bson.M{"$in": level}})
+		if err != nil {
+			return 0, err
+		}
+		var replies []commentItem
+		err = cur.All(ctx, &replies)
+		if err != nil {
+			return 0, err
+		}
+		level = nil
+		for _, r := range replies {
+			level = append(level, r.ID)
+		}
+		thread = append(thread, level...)
+	}
+
+	res, err := m.comments.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": thread}})
+	if err != nil {
+		return 0, err
+	}
+	if res.DeletedCount == 0 {
+		return 0, errCommentNotFound
+	}
+	return int(res.DeletedCount), nil
+}
+
+func (m *mongoStore) ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	cur, err := m.revisions.Find(ctx, bson.M{"blog_id": blogID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		data := &revisionItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *mongoStore) GetRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error) {
+	data := &revisionItem{}
+	filter := bson.M{"blog_id": blogID, "version": version}
+	if err := m.revisions.FindOne(ctx, filter).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errRevisionNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) CreateAuthor(ctx context.Context, author *authorItem) (*authorItem, error) {
+	res, err := m.authors.InsertOne(ctx, author)
+	if err != nil {
+		return nil, err
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v to OID", res.InsertedID)
+	}
+
+	created := *author
+	created.ID = oid
+	return &created, nil
+}
+
+func (m *mongoStore) GetAuthor(ctx context.Context, id primitive.ObjectID) (*authorItem, error) {
+	data := &authorItem{}
+	if err := m.authors.FindOne(ctx, bson.M{"_id": id}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errAuthorNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) UpdateAuthor(ctx context.Context, author *authorItem) (*authorItem, error) {
+	update := bson.M{"$set": bson.M{
+		"display_name": author.DisplayName,
+		"email":        author.Email,
+		"bio":          author.Bio,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	data := &authorItem{}
+	res := m.authors.FindOneAndUpdate(ctx, bson.M{"_id": author.ID}, update, opts)
+	if err := res.Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errAuthorNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) ListAuthors(ctx context.Context, fn func(*authorItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cur, err := m.authors.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		data := &authorItem{}
+		if err := cur.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *mongoStore) CreateAttachment(ctx context.Context, attachment *attachmentItem) (*attachmentItem, error) {
+	if err := m.collection.FindOne(ctx, live(bson.M{"_id": attachment.BlogID})).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	if _, err := m.attachments.InsertOne(ctx, attachment); err != nil {
+		return nil, err
+	}
+	created := *attachment
+	return &created, nil
+}
+
+func (m *mongoStore) GetAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error) {
+	data := &attachmentItem{}
+	if err := m.attachments.FindOne(ctx, bson.M{"_id": id}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errAttachmentNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// React replaces the reaction record first and then moves the counts of
+// the blog from the reaction it replaced to the new one. Each step is
+// atomic, so concurrent reactions never lose a count, but should the
+// server stop between the two the counts miss that one reaction.
+func (m *mongoStore) React(ctx context.Context, blogID primitive.ObjectID, user, reaction string) (*blogItem, error) {
+	if _, err := m.Get(ctx, blogID); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": reactionID(blogID, user)}
+	update := bson.M{"$set": bson.M{"blog_id": blogID, "user_id": user, "reaction": reaction}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	old := &reactionItem{}
+	for attempt := 1; ; attempt++ {
+		err := m.reactions.FindOneAndUpdate(ctx, filter, update, opts).Decode(old)
+		if err == nil || err == mongo.ErrNoDocuments {
+			break
+		}
+		// two first reactions of a user raced to insert the record;
+		// the retry updates it instead
+		if !isDuplicateKey(err) || attempt == 2 {
+			return nil, err
+		}
+	}
+
+	inc := bson.M{}
+	if old.Reaction != reaction {
+		inc["reactions."+reaction] = 1
+		if old.Reaction != "" {
+			inc["reactions."+old.Reaction] = -1
+		}
+	}
+	return m.increment(ctx, blogID, inc)
+}
+
+func (m *mongoStore) Unreact(ctx context.Context, blogID primitive.ObjectID, user string) (*blogItem, error) {
+	if _, err := m.Get(ctx, blogID); err != nil {
+		return nil, err
+	}
+
+	old := &reactionItem{}
+	if err := m.reactions.FindOneAndDelete(ctx, bson.M{"_id": reactionID(blogID, user)}).Decode(old); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errReactionNotFound
+		}
+		return nil, err
+	}
+	return m.increment(ctx, blogID, bson.M{"reactions." + old.Reaction: -1})
+}
+
+// RecordView claims the view record of the viewer, which only works if
+// there is none or it expired: with an unexpired one the upsert tries to
+// insert a second record with the same id and fails.
+func (m *mongoStore) RecordView(ctx context.Context, blogID primitive.ObjectID, viewer string, window time.Duration) (*blogItem, bool, error) {
+	if _, err := m.Get(ctx, blogID); err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UTC()
+	filter := bson.M{"_id": reactionID(blogID, viewer), "expires_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"blog_id": blogID, "expires_at": now.Add(window)}}
+	_, err := m.views.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		data, err := m.Get(ctx, blogID)
+		return data, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := m.increment(ctx, blogID, bson.M{"views": 1})
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// increment applies inc to the counters of a blog and returns it after
+func (m *mongoStore) increment(ctx context.Context, blogID primitive.ObjectID, inc bson.M) (*blogItem, error) {
+	if len(inc) == 0 {
+		return m.Get(ctx, blogID)
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	data := &blogItem{}
+	err := m.collection.FindOneAndUpdate(ctx, bson.M{"_id": blogID}, bson.M{"$inc": inc}, opts).Decode(data)
+	if err == mongo.ErrNoDocuments || (err == nil && data.DeletedAt != nil) {
+		// trashed or purged since it was read; a trashed blog keeps its
+		// counts in line with its reactions in case it is restored
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
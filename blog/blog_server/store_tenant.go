@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tenantStore is the BlogStore the services use. It passes every call on
+// to the store of the tenant in its context, so a handler cannot reach
+// the blogs of another tenant however it is written, and a call that
+// comes without a tenant fails with errNoTenant.
+type tenantStore struct{}
+
+func (tenantStore) store(ctx context.Context) (BlogStore, error) {
+	t, err := tenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.store, nil
+}
+
+func (ts tenantStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Create(ctx, item)
+}
+
+func (ts tenantStore) CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.CreateOnce(ctx, item, key)
+}
+
+func (ts tenantStore) Get(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+func (ts tenantStore) GetBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBySlug(ctx, slug)
+}
+
+func (ts tenantStore) Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Update(ctx, item, fields)
+}
+
+func (ts tenantStore) Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Delete(ctx, id)
+}
+
+func (ts tenantStore) List(ctx context.Context, opts listOptions, fn func(*blogItem) error) error {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return err
+	}
+	return s.List(ctx, opts, fn)
+}
+
+func (ts tenantStore) ListTags(ctx context.Context) ([]tagCount, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListTags(ctx)
+}
+
+func (ts tenantStore) ListTrash(ctx context.Context, fn func(*blogItem) error) error {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return err
+	}
+	return s.ListTrash(ctx, fn)
+}
+
+func (ts tenantStore) Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Restore(ctx, id)
+}
+
+func (ts tenantStore) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.Purge(ctx, cutoff)
+}
+
+func (ts tenantStore) SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.SetStatus(ctx, id, from, to, publishAt)
+}
+
+func (ts tenantStore) PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.PublishDue(ctx, now)
+}
+
+func (ts tenantStore) ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return err
+	}
+	return s.ListRevisions(ctx, blogID, fn)
+}
+
+func (ts tenantStore) GetRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRevision(ctx, blogID, version)
+}
+
+func (ts tenantStore) AddComment(ctx context.Context, comment *commentItem) (*commentItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.AddComment(ctx, comment)
+}
+
+func (ts tenantStore) ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return err
+	}
+	return s.ListComments(ctx, blogID, fn)
+}
+
+func (ts tenantStore) DeleteComment(ctx context.Context, id primitive.ObjectID) (int, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.DeleteComment(ctx, id)
+}
+
+func (ts tenantStore) CreateAuthor(ctx context.Context, author *authorItem) (*authorItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateAuthor(ctx, author)
+}
+
+func (ts tenantStore) GetAuthor(ctx context.Context, id primitive.ObjectID) (*authorItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAuthor(ctx, id)
+}
+
+func (ts tenantStore) UpdateAuthor(ctx context.Context, author *authorItem) (*authorItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.UpdateAuthor(ctx, author)
+}
+
+func (ts tenantStore) ListAuthors(ctx context.Context, fn func(*authorItem) error) error {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return err
+	}
+	return s.ListAuthors(ctx, fn)
+}
+
+func (ts tenantStore) CreateAttachment(ctx context.Context, attachment *attachmentItem) (*attachmentItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateAttachment(ctx, attachment)
+}
+
+func (ts tenantStore) GetAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAttachment(ctx, id)
+}
+
+func (ts tenantStore) React(ctx context.Context, blogID primitive.ObjectID, user, reaction string) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.React(ctx, blogID, user, reaction)
+}
+
+func (ts tenantStore) Unreact(ctx context.Context, blogID primitive.ObjectID, user string) (*blogItem, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Unreact(ctx, blogID, user)
+}
+
+func (ts tenantStore) RecordView(ctx context.Context, blogID primitive.ObjectID, viewer string, window time.Duration) (*blogItem, bool, error) {
+	s, err := ts.store(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return s.RecordView(ctx, blogID, viewer, window)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tenantMetadataKey = "tenant-id"
+	adminMetadataKey  = "admin-token"
+)
+
+// errNoTenant is returned by a tenantStore for a call whose context does
+// not say which tenant it is for
+var errNoTenant = errors.New("no tenant in the request context")
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// tenantConfig maps a tenant to where its data lives. The tenants file
+// holds a JSON array of them; the fields other than id may be left out.
+type tenantConfig struct {
+	ID string `json:"id"`
+	// Database defaults to myblogdb_<id>
+	Database         string `json:"database"`
+	CollectionPrefix string `json:"collection_prefix"`
+	// DataFile defaults to <id>.db next to the -data-file
+	DataFile string `json:"data_file"`
+	// AttachmentDir defaults to <id> inside the -attachment-dir
+	AttachmentDir string `json:"attachment_dir"`
+	// Title and PublicURL, when set, stand in for -feed-title and
+	// -public-url in the feeds of the tenant
+	Title     string `json:"title"`
+	PublicURL string `json:"public_url"`
+}
+
+// loadTenants reads the tenants from path and fills in their defaults.
+// Without a path there is a single tenant with no id, which keeps its data
+// where a server without tenants always has.
+func loadTenants(path string, cfg storeConfig, attachmentDir string) ([]tenantConfig, error) {
+	if path == "" {
+		return []tenantConfig{{
+			Database:      "myblogdb",
+			DataFile:      cfg.DataFile,
+			AttachmentDir: attachmentDir,
+		}}, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []tenantConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("cannot parse %v: %v", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%v lists no tenants", path)
+	}
+
+	// no two tenants may share a place, or they would see each other's blogs
+	places := make(map[string]string)
+	claim := func(id, kind, place string) error {
+		key := kind + " " + place
+		if other, ok := places[key]; ok {
+			return fmt.Errorf("tenants %v and %v both use %v", other, id, key)
+		}
+		places[key] = id
+		return nil
+	}
+	for i := range configs {
+		t := &configs[i]
+		if !tenantIDPattern.MatchString(t.ID) {
+			return nil, fmt.Errorf("tenant id %q must be lowercase letters, digits, dashes and underscores", t.ID)
+		}
+		if t.Database == "" {
+			t.Database = "myblogdb_" + t.ID
+		}
+		if t.DataFile == "" {
+			t.DataFile = filepath.Join(filepath.Dir(cfg.DataFile), t.ID+".db")
+		}
+		if t.AttachmentDir == "" {
+			t.AttachmentDir = filepath.Join(attachmentDir, t.ID)
+		}
+		for _, err := range []error{
+			claim(t.ID, "tenant", t.ID),
+			claim(t.ID, "mongo collections", t.Database+"."+t.CollectionPrefix+"*"),
+			claim(t.ID, "data file", filepath.Clean(t.DataFile)),
+			claim(t.ID, "attachment directory", filepath.Clean(t.AttachmentDir)),
+		} {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return configs, nil
+}
+
+// tenantOptions holds the startup flags every tenant is set up with
+type tenantOptions struct {
+	WatchBuffer    int
+	ScheduleCheck  time.Duration
+	TrashRetention time.Duration
+	PurgeEvery     time.Duration
+	// CacheSize is how many blogs the cache holds, 0 for no cache
+	CacheSize int
+	CacheTTL  time.Duration
+}
+
+// tenant holds what a tenant has to itself: its store, with a cache, a
+// search index and a change feed kept over it, the scheduler and purger
+// working on it and the blobs of its attachments
+type tenant struct {
+	config    tenantConfig
+	store     BlogStore
+	cache     *blogCache // nil without a cache
+	index     *searchIndex
+	feed      *changeFeed
+	scheduler *scheduler
+	blobs     *blobStore
+	close     func()
+}
+
+func openTenant(cfg storeConfig, tc tenantConfig, opts tenantOptions) (*tenant, error) {
+	store, closeStore, err := openStore(cfg, tc)
+	if err != nil {
+		return nil, err
+	}
+	var cache *blogCache
+	if opts.CacheSize > 0 {
+		cache = newBlogCache(opts.CacheSize, opts.CacheTTL)
+		store = newCachedStore(store, cache)
+	}
+	index := newSearchIndex()
+	indexed, err := newIndexedStore(context.Background(), store, index)
+	if err != nil {
+		closeStore()
+		return nil, fmt.Errorf("cannot build the search index: %v", err)
+	}
+	blobs, err := newBlobStore(tc.AttachmentDir)
+	if err != nil {
+		closeStore()
+		return nil, fmt.Errorf("cannot open the attachment directory: %v", err)
+	}
+	feed := newChangeFeed(opts.WatchBuffer)
+	watched := newWatchedStore(indexed, feed)
+
+	sched := startScheduler(watched, opts.ScheduleCheck)
+	stopPurger := startPurger(watched, blobs, opts.TrashRetention, opts.PurgeEvery)
+	return &tenant{
+		config:    tc,
+		store:     watched,
+		cache:     cache,
+		index:     index,
+		feed:      feed,
+		scheduler: sched,
+		blobs:     blobs,
+		close: func() {
+			stopPurger()
+			sched.Stop()
+			closeStore()
+		},
+	}, nil
+}
+
+// tenants are all the tenants a server has, by id
+type tenants map[string]*tenant
+
+func openTenants(cfg storeConfig, configs []tenantConfig, opts tenantOptions) (tenants, error) {
+	ts := make(tenants, len(configs))
+	for _, tc := range configs {
+		t, err := openTenant(cfg, tc, opts)
+		if err != nil {
+			ts.close()
+			return nil, fmt.Errorf("cannot open tenant %q: %v", tc.ID, err)
+		}
+		ts[tc.ID] = t
+	}
+	return ts, nil
+}
+
+func (ts tenants) close() {
+	for _, t := range ts {
+		t.close()
+	}
+}
+
+// resolve returns ctx with the tenant named in its tenant-id metadata, or
+// the tenant without an id if the server has no others
+func (ts tenants) resolve(ctx context.Context) (context.Context, error) {
+	var id string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(tenantMetadataKey); len(values) > 0 {
+		id = values[0]
+	}
+	return ts.withTenant(ctx, id)
+}
+
+// withTenant returns ctx with the tenant id, or the tenant without an id
+// for an empty one if the server has no others
+func (ts tenants) withTenant(ctx context.Context, id string) (context.Context, error) {
+	if id == "" {
+		if _, ok := ts[""]; !ok {
+			return nil, status.Errorf(
+				codes.InvalidArgument,
+				fmt.Sprintf("Missing %v metadata naming the tenant", tenantMetadataKey),
+			)
+		}
+	}
+	t, ok := ts[id]
+	if !ok {
+		return nil, status.Errorf(
+			codes.PermissionDenied,
+			fmt.Sprintf("Unknown tenant: %v", id),
+		)
+	}
+	return context.WithValue(ctx, tenantKey{}, t), nil
+}
+
+// tenantKey is the context key of the tenant a request is for
+type tenantKey struct{}
+
+func tenantFrom(ctx context.Context) (*tenant, error) {
+	t, ok := ctx.Value(tenantKey{}).(*tenant)
+	if !ok {
+		return nil, errNoTenant
+	}
+	return t, nil
+}
+
+// wakeScheduler wakes the scheduler of the tenant in ctx, after a blog
+// was scheduled
+func wakeScheduler(ctx context.Context) {
+	if t, err := tenantFrom(ctx); err == nil {
+		t.scheduler.wake()
+	}
+}
+
+// forTenant reports whether method is one of the calls that are made for
+// a tenant, which is all of them but tenant administration
+func forTenant(method string) bool {
+	return !strings.HasPrefix(method, "/blog.TenantService/")
+}
+
+func (ts tenants) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if forTenant(info.FullMethod) {
+		var err error
+		if ctx, err = ts.resolve(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (ts tenants) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !forTenant(info.FullMethod) {
+		return handler(srv, stream)
+	}
+	ctx, err := ts.resolve(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &tenantStream{ServerStream: stream, ctx: ctx})
+}
+
+// tenantStream is a server stream whose context carries its tenant
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
+
+// hasAdminToken reports whether the call of ctx carries adminToken in its
+// metadata; with no adminToken set no call does
+func hasAdminToken(ctx context.Context, adminToken string) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	if values := md.Get(adminMetadataKey); len(values) > 0 {
+		token = values[0]
+	}
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+type tenantServer struct {
+	blogpb.UnimplementedTenantServiceServer
+
+	tenants tenants
+	kind    string
+	// adminToken has to come with every call; without one the service
+	// turns every call down
+	adminToken string
+}
+
+func (s *tenantServer) ListTenants(ctx context.Context, req *blogpb.ListTenantsRequest) (*blogpb.ListTenantsResponse, error) {
+	fmt.Println("List tenants request")
+
+	if !hasAdminToken(ctx, s.adminToken) {
+		return nil, status.Errorf(
+			codes.PermissionDenied,
+			fmt.Sprintf("Listing tenants needs the admin token in %v metadata", adminMetadataKey),
+		)
+	}
+
+	res := &blogpb.ListTenantsResponse{}
+	for _, t := range s.tenants {
+		pb := &blogpb.Tenant{
+			Id:            t.config.ID,
+			Store:         s.kind,
+			AttachmentDir: t.config.AttachmentDir,
+			Cache:         t.cache.stats(),
+		}
+		switch s.kind {
+		case "mongo":
+			pb.Database = t.config.Database
+			pb.CollectionPrefix = t.config.CollectionPrefix
+		case "file":
+			pb.DataFile = t.config.DataFile
+		}
+		res.Tenants = append(res.Tenants, pb)
+	}
+	sort.Slice(res.Tenants, func(i, j int) bool {
+		return res.Tenants[i].GetId() < res.Tenants[j].GetId()
+	})
+	return res, nil
+}
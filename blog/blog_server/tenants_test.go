@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	// each reports whether the call made for the tenant of ctx sees the blog
+	ops := []struct {
+		name string
+		sees func(t *testing.T, ctx context.Context, s *server, id string) bool
+	}{
+		{"read", func(t *testing.T, ctx context.Context, s *server, id string) bool {
+			_, err := s.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: id})
+			if err != nil && status.Code(err) != codes.NotFound {
+				t.Fatal(err)
+			}
+			return err == nil
+		}},
+		{"list", func(t *testing.T, ctx context.Context, s *server, id string) bool {
+			stream := &listStream{ctx: ctx}
+			if err := s.ListBlogs(&blogpb.ListBlogsRequest{}, stream); err != nil {
+				t.Fatal(err)
+			}
+			return len(stream.sent) > 0
+		}},
+		{"watch", func(t *testing.T, ctx context.Context, s *server, id string) bool {
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			stream := &watchStream{ctx: ctx}
+			if err := s.WatchBlogs(&blogpb.WatchBlogsRequest{HasAfterSequence: true}, stream); err != nil {
+				t.Fatal(err)
+			}
+			return len(stream.sent) > 0
+		}},
+		{"search", func(t *testing.T, ctx context.Context, s *server, id string) bool {
+			res, err := s.SearchBlogs(ctx, &blogpb.SearchBlogsRequest{Query: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return len(res.GetResults()) > 0
+		}},
+	}
+	tests := []struct {
+		tenant string
+		sees   bool
+	}{
+		{"a", true},
+		{"b", false},
+	}
+	for _, op := range ops {
+		for _, tt := range tests {
+			t.Run(op.name+" in "+tt.tenant, func(t *testing.T) {
+				ts := testTenants(t, "a", "b")
+				ctx := testContext(t, ts, "a")
+				s := &server{store: tenantStore{}}
+				created, err := s.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{
+					AuthorId: testAuthor(t, ctx), Title: "Secret plans", Content: "c", Status: blogpb.Blog_PUBLISHED,
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if sees := op.sees(t, testContext(t, ts, tt.tenant), s, created.GetBlog().GetId()); sees != tt.sees {
+					t.Errorf("tenant %v sees the blog of tenant a: %v, want %v", tt.tenant, sees, tt.sees)
+				}
+			})
+		}
+	}
+}
+
+func TestUnknownTenant(t *testing.T) {
+	ts := testTenants(t, "a")
+	if _, err := ts.withTenant(context.Background(), "b"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("a call for an unknown tenant failed with %v, want PermissionDenied", err)
+	}
+}
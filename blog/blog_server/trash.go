@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) ListTrash(req *blogpb.ListTrashRequest, stream blogpb.BlogService_ListTrashServer) error {
+	fmt.Println("List trash request")
+
+	sendErr := error(nil)
+	err := s.store.ListTrash(stream.Context(), func(data *blogItem) error {
+		sendErr = stream.Send(&blogpb.ListTrashResponse{
+			Blog: dataToBlogPb(data),
+		})
+		return sendErr
+	})
+	if err != nil {
+		if err == sendErr {
+			return err
+		}
+		return status.Errorf(
+			codes.Internal,
+			fmt.Sprintf("Unknown internal error: %v", err),
+		)
+	}
+	return nil
+}
+
+func (s *server) RestoreBlog(ctx context.Context, req *blogpb.RestoreBlogRequest) (*blogpb.RestoreBlogResponse, error) {
+	fmt.Println("Restore blog request")
+
+	if err := validateBlogID(req.GetBlogId()); err != nil {
+		return nil, err
+	}
+	oid, err := parseBlogID(req.GetBlogId())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.Restore(ctx, oid)
+	if err != nil {
+		if err == errNotFound {
+			return nil, status.Errorf(
+				codes.NotFound,
+				fmt.Sprintf("Cannot find blog in the trash with specified ID: %v", req.GetBlogId()),
+			)
+		}
+		return nil, storeError(err, req.GetBlogId())
+	}
+
+	return &blogpb.RestoreBlogResponse{
+		Blog: dataToBlogPb(data),
+	}, nil
+}
+
+// startPurger removes blogs that have been in the trash for longer than
+// retention, checking every interval, and then the blobs no attachment
+// refers to any more. It returns a func that stops it.
+func startPurger(store BlogStore, blobs *blobStore, retention, interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := store.Purge(context.Background(), time.Now().Add(-retention))
+				if err != nil {
+					fmt.Printf("Purging the trash failed: %v\n", err)
+				}
+				if n > 0 {
+					fmt.Printf("Purged %v blogs from the trash\n", n)
+				}
+				n, err = blobs.sweep(context.Background(), store, blobGrace)
+				if err != nil {
+					fmt.Printf("Removing unused attachment files failed: %v\n", err)
+				}
+				if n > 0 {
+					fmt.Printf("Removed %v unused attachment files\n", n)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
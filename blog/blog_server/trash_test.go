@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// trashStream collects what ListTrash sends
+type trashStream struct {
+	blogpb.BlogService_ListTrashServer
+	ctx  context.Context
+	sent []*blogpb.ListTrashResponse
+}
+
+func (s *trashStream) Context() context.Context { return s.ctx }
+
+func (s *trashStream) Send(res *blogpb.ListTrashResponse) error {
+	s.sent = append(s.sent, res)
+	return nil
+}
+
+func TestTrash(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   func(ctx context.Context, s *server, id string) error
+		read    codes.Code // of ReadBlog afterwards
+		trashed bool       // whether ListTrash has the blog afterwards
+	}{
+		{"deleted", func(ctx context.Context, s *server, id string) error {
+			_, err := s.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: id})
+			return err
+		}, codes.NotFound, true},
+		{"restored", func(ctx context.Context, s *server, id string) error {
+			if _, err := s.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: id}); err != nil {
+				return err
+			}
+			_, err := s.RestoreBlog(ctx, &blogpb.RestoreBlogRequest{BlogId: id})
+			return err
+		}, codes.OK, false},
+		{"within retention", func(ctx context.Context, s *server, id string) error {
+			if _, err := s.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: id}); err != nil {
+				return err
+			}
+			_, err := s.store.Purge(ctx, time.Now().Add(-time.Hour))
+			return err
+		}, codes.NotFound, true},
+		{"purged after retention", func(ctx context.Context, s *server, id string) error {
+			if _, err := s.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: id}); err != nil {
+				return err
+			}
+			if _, err := s.store.Purge(ctx, time.Now().Add(time.Minute)); err != nil {
+				return err
+			}
+			// a purged blog is gone for good
+			_, err := s.RestoreBlog(ctx, &blogpb.RestoreBlogRequest{BlogId: id})
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("restoring a purged blog failed with %v, want NotFound", err)
+			}
+			return nil
+		}, codes.NotFound, false},
+		{"not deleted", func(ctx context.Context, s *server, id string) error {
+			_, err := s.store.Purge(ctx, time.Now().Add(time.Minute))
+			return err
+		}, codes.OK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			s := &server{store: tenantStore{}}
+			created, err := s.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{
+				AuthorId: testAuthor(t, ctx), Title: "t", Content: "c", Status: blogpb.Blog_PUBLISHED,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := created.GetBlog().GetId()
+
+			if err := tt.steps(ctx, s, id); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: id}); status.Code(err) != tt.read {
+				t.Errorf("ReadBlog failed with %v, want %v", err, tt.read)
+			}
+			stream := &trashStream{ctx: ctx}
+			if err := s.ListTrash(&blogpb.ListTrashRequest{}, stream); err != nil {
+				t.Fatal(err)
+			}
+			trashed := false
+			for _, res := range stream.sent {
+				trashed = trashed || res.GetBlog().GetId() == id
+			}
+			if trashed != tt.trashed {
+				t.Errorf("the blog is in the trash: %v, want %v", trashed, tt.trashed)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// limits on what a blog may hold, lengths are in characters except for
+// content, which is in bytes
+const (
+	maxTitleLength    = 200
+	maxContentBytes   = 1 << 20
+	maxTags           = 20
+	maxTagLength      = 50
+	maxCategoryLength = 50
+	maxQueryLength    = 500
+	maxCommentBytes   = 10 << 10
+)
+
+// violations collects what is wrong with a request field by field, so a
+// client can point at each field instead of parsing a message
+type violations []*errdetails.BadRequest_FieldViolation
+
+func (v *violations) add(field string, format string, args ...interface{}) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns nil if nothing was added, otherwise an InvalidArgument status
+// error with the violations attached as google.rpc.BadRequest
+func (v violations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	var msg []string
+	for _, fv := range v {
+		msg = append(msg, fv.GetField()+": "+fv.GetDescription())
+	}
+	st := status.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("Invalid request: %v", strings.Join(msg, "; ")),
+	)
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// fieldError is the error for a request with a single bad field
+func fieldError(field string, format string, args ...interface{}) error {
+	v := violations{}
+	v.add(field, format, args...)
+	return v.err()
+}
+
+// id checks that field holds an ObjectID
+func (v *violations) id(field, id string) {
+	if id == "" {
+		v.add(field, "is required")
+		return
+	}
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		v.add(field, "is not a valid ID: %q", id)
+	}
+}
+
+// optionalID checks field like id but lets it be empty
+func (v *violations) optionalID(field, id string) {
+	if id != "" {
+		v.id(field, id)
+	}
+}
+
+// line checks a single line of text: valid UTF-8, no control characters
+// and at most max characters
+func (v *violations) line(field, s string, required bool, max int) {
+	switch {
+	case s == "":
+		if required {
+			v.add(field, "is required")
+		}
+	case !utf8.ValidString(s):
+		v.add(field, "is not valid UTF-8")
+	case strings.IndexFunc(s, unicode.IsControl) >= 0:
+		v.add(field, "must not contain control characters")
+	case strings.TrimSpace(s) == "":
+		v.add(field, "must not be blank")
+	case utf8.RuneCountInString(s) > max:
+		v.add(field, "must be at most %v characters, got %v", max, utf8.RuneCountInString(s))
+	}
+}
+
+// text checks text spanning several lines, which may hold tabs and line
+// breaks but no other control characters
+func (v *violations) text(field, s string, max int) {
+	switch {
+	case !utf8.ValidString(s):
+		v.add(field, "is not valid UTF-8")
+	case strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t'
+	}) >= 0:
+		v.add(field, "must not contain control characters other than tabs and line breaks")
+	case len(s) > max:
+		v.add(field, "must be at most %v bytes, got %v", max, len(s))
+	}
+}
+
+func (v *violations) tags(field string, tags []string) {
+	if len(tags) > maxTags {
+		v.add(field, "must have at most %v tags, got %v", maxTags, len(tags))
+		return
+	}
+	for i, tag := range tags {
+		v.line(fmt.Sprintf("%v[%v]", field, i), tag, false, maxTagLength)
+	}
+}
+
+func (v *violations) statuses(field string, statuses []blogpb.Blog_Status) {
+	for i, st := range statuses {
+		if _, ok := statusFromPb[st]; !ok {
+			v.add(fmt.Sprintf("%v[%v]", field, i), "is not a known status: %v", st)
+		}
+	}
+}
+
+func (v *violations) nonNegative(field string, n int64) {
+	if n < 0 {
+		v.add(field, "must not be negative, got %v", n)
+	}
+}
+
+func (v *violations) version(field string, version int64) {
+	if version < 1 {
+		v.add(field, "must be a version starting at 1, got %v", version)
+	}
+}
+
+// blog checks the fields of a blog that a client writes, or only the
+// ones listed in fields if it is not nil. The id, status and publish time
+// are up to the caller.
+func (v *violations) blog(field string, blog *blogpb.Blog, fields []string) {
+	if blog == nil {
+		v.add(field, "is required")
+		return
+	}
+	if fields == nil {
+		fields = blogFields
+	}
+	for _, f := range fields {
+		switch f {
+		case "author_id":
+			v.id(field+".author_id", blog.GetAuthorId())
+		case "title":
+			v.line(field+".title", blog.GetTitle(), true, maxTitleLength)
+		case "content":
+			v.text(field+".content", blog.GetContent(), maxContentBytes)
+		case "tags":
+			v.tags(field+".tags", blog.GetTags())
+		case "category":
+			v.line(field+".category", blog.GetCategory(), false, maxCategoryLength)
+		}
+	}
+}
+
+func validateCreateBlog(req *blogpb.CreateBlogRequest) error {
+	v := violations{}
+	v.blog("blog", req.GetBlog(), nil)
+	return v.err()
+}
+
+func validateUpdateBlog(req *blogpb.UpdateBlogRequest) error {
+	v := violations{}
+	if req.GetBlog() != nil {
+		v.id("blog.id", req.GetBlog().GetId())
+		v.nonNegative("blog.version", req.GetBlog().GetVersion())
+	}
+	v.updateMask("update_mask", req.GetUpdateMask())
+	if len(v) == 0 {
+		v.blog("blog", req.GetBlog(), updateFields(req.GetUpdateMask()))
+	}
+	return v.err()
+}
+
+func validateReadBlog(req *blogpb.ReadBlogRequest) error {
+	v := violations{}
+	v.id("blog_id", req.GetBlogId())
+	v.readMask("read_mask", req.GetReadMask())
+	return v.err()
+}
+
+// validateBlogID checks a request that only names a blog
+func validateBlogID(id string) error {
+	v := violations{}
+	v.id("blog_id", id)
+	return v.err()
+}
+
+func validateGetBlogBySlug(req *blogpb.GetBlogBySlugRequest) error {
+	v := violations{}
+	v.line("slug", req.GetSlug(), true, maxSlugLength+20)
+	return v.err()
+}
+
+func validateListBlogs(req *blogpb.ListBlogsRequest) error {
+	v := violations{}
+	v.nonNegative("page_size", int64(req.GetPageSize()))
+	if _, ok := blogpb.ListBlogsRequest_SortOrder_name[int32(req.GetSortOrder())]; !ok {
+		v.add("sort_order", "is not a known sort order: %v", req.GetSortOrder())
+	}
+	if _, ok := blogpb.ListBlogsRequest_TagMatch_name[int32(req.GetTagMatch())]; !ok {
+		v.add("tag_match", "is not a known tag match: %v", req.GetTagMatch())
+	}
+	v.tags("tags", req.GetTags())
+	v.line("category", req.GetCategory(), false, maxCategoryLength)
+	v.optionalID("author_id", req.GetAuthorId())
+	v.statuses("statuses", req.GetStatuses())
+	v.readMask("read_mask", req.GetReadMask())
+	return v.err()
+}
+
+func validateWatchBlogs(req *blogpb.WatchBlogsRequest) error {
+	v := violations{}
+	v.nonNegative("after_sequence", req.GetAfterSequence())
+	v.optionalID("author_id", req.GetAuthorId())
+	v.tags("tags", req.GetTags())
+	return v.err()
+}
+
+func validateSearchBlogs(req *blogpb.SearchBlogsRequest) error {
+	v := violations{}
+	v.line("query", req.GetQuery(), true, maxQueryLength)
+	v.nonNegative("limit", int64(req.GetLimit()))
+	return v.err()
+}
+
+func validateRevision(blogID string, version int64) error {
+	v := violations{}
+	v.id("blog_id", blogID)
+	v.version("version", version)
+	return v.err()
+}
+
+func validateDiffRevisions(req *blogpb.DiffRevisionsRequest) error {
+	v := violations{}
+	v.id("blog_id", req.GetBlogId())
+	v.version("from_version", req.GetFromVersion())
+	v.version("to_version", req.GetToVersion())
+	return v.err()
+}
+
+func validateAddComment(req *blogpb.AddCommentRequest) error {
+	v := violations{}
+	comment := req.GetComment()
+	if comment == nil {
+		v.add("comment", "is required")
+		return v.err()
+	}
+	v.id("comment.blog_id", comment.GetBlogId())
+	v.optionalID("comment.parent_id", comment.GetParentId())
+	v.id("comment.author_id", comment.GetAuthorId())
+	if strings.TrimSpace(comment.GetContent()) == "" {
+		v.add("comment.content", "is required")
+	} else {
+		v.text("comment.content", comment.GetContent(), maxCommentBytes)
+	}
+	return v.err()
+}
+
+func validateReactToBlog(req *blogpb.ReactToBlogRequest) error {
+	v := violations{}
+	v.id("blog_id", req.GetBlogId())
+	v.line("user_id", req.GetUserId(), true, maxUserIDLength)
+	if _, ok := reactionFromPb[req.GetReaction()]; !ok {
+		v.add("reaction", "is not a known reaction: %v", req.GetReaction())
+	}
+	return v.err()
+}
+
+func validateRemoveReaction(req *blogpb.RemoveReactionRequest) error {
+	v := violations{}
+	v.id("blog_id", req.GetBlogId())
+	v.line("user_id", req.GetUserId(), true, maxUserIDLength)
+	return v.err()
+}
+
+func validateRecordView(req *blogpb.RecordViewRequest) error {
+	v := violations{}
+	v.id("blog_id", req.GetBlogId())
+	v.line("viewer_id", req.GetViewerId(), true, maxUserIDLength)
+	return v.err()
+}
+
+func validateExportBlogs(req *blogpb.ExportBlogsRequest) error {
+	v := violations{}
+	v.statuses("statuses", req.GetStatuses())
+	return v.err()
+}
+
+// validateImportedBlog checks one blog of an import, which may also carry
+// any status
+func validateImportedBlog(blog *blogpb.Blog) error {
+	v := violations{}
+	v.blog("blog", blog, nil)
+	if _, ok := statusFromPb[blog.GetStatus()]; blog != nil && !ok {
+		v.add("blog.status", "is not a known status: %v", blog.GetStatus())
+	}
+	return v.err()
+}
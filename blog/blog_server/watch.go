@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grpc-go-course/blog/blogpb"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// changeEvent is one write to a blog as seen by WatchBlogs
+type changeEvent struct {
+	Seq  int64
+	Kind blogpb.WatchBlogsResponse_Kind
+	Blog *blogItem
+	At   time.Time
+}
+
+// changeFeed numbers the writes to blogs and keeps the latest of them in a
+// ring buffer, so watchers that reconnect can pick up where they left off.
+// It lives in process instead of on Mongo change streams, which need a
+// replica set, and works the same on every BlogStore.
+type changeFeed struct {
+	mu     sync.Mutex
+	buf    []changeEvent // event n is at buf[(n-1)%len(buf)]
+	last   int64         // sequence of the newest event, 0 before the first
+	notify chan struct{} // closed and replaced on every publish
+	// newest holds the newest buffered event of each blog, so an update
+	// that finishes after a later one is not published over it
+	newest map[primitive.ObjectID]changeEvent
+}
+
+func newChangeFeed(size int) *changeFeed {
+	if size < 1 {
+		size = 1
+	}
+	return &changeFeed{
+		buf:    make([]changeEvent, size),
+		notify: make(chan struct{}),
+		newest: make(map[primitive.ObjectID]changeEvent),
+	}
+}
+
+// publish appends an event for item and wakes up every watcher. Writes
+// reach it in the order they finish, not the order the store applied them,
+// so an event for an older version of a blog than one already published
+// is dropped; writes that keep the version, like status changes, come out
+// in the order they finished.
+func (f *changeFeed) publish(kind blogpb.WatchBlogsResponse_Kind, item *blogItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if prev, ok := f.newest[item.ID]; ok && item.Version < prev.Blog.Version {
+		return
+	}
+	f.last++
+	slot := &f.buf[(f.last-1)%int64(len(f.buf))]
+	if slot.Blog != nil && f.newest[slot.Blog.ID].Seq == slot.Seq {
+		// the event leaving the buffer was the newest of its blog
+		delete(f.newest, slot.Blog.ID)
+	}
+	*slot = changeEvent{
+		Seq:  f.last,
+		Kind: kind,
+		Blog: item,
+		At:   time.Now().UTC(),
+	}
+	f.newest[item.ID] = *slot
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// head returns the sequence of the newest event
+func (f *changeFeed) head() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last
+}
+
+// since returns the buffered events after seq, oldest first, along with a
+// channel that is closed once a newer event is published. It fails with
+// OUT_OF_RANGE when events after seq have already left the buffer or seq
+// was never handed out.
+func (f *changeFeed) since(seq int64) ([]changeEvent, <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seq > f.last {
+		return nil, nil, status.Errorf(
+			codes.OutOfRange,
+			fmt.Sprintf("Sequence %v is ahead of the newest change %v", seq, f.last),
+		)
+	}
+	if oldest := f.last - int64(len(f.buf)) + 1; seq+1 < oldest {
+		return nil, nil, status.Errorf(
+			codes.OutOfRange,
+			fmt.Sprintf("Changes after sequence %v are no longer buffered, the oldest is %v", seq, oldest),
+		)
+	}
+
+	events := make([]changeEvent, 0, f.last-seq)
+	for n := seq + 1; n <= f.last; n++ {
+		events = append(events, f.buf[(n-1)%int64(len(f.buf))])
+	}
+	return events, f.notify, nil
+}
+
+func (s *server) WatchBlogs(req *blogpb.WatchBlogsRequest, stream blogpb.BlogService_WatchBlogsServer) error {
+	fmt.Println("Watch blogs request")
+
+	if err := validateWatchBlogs(req); err != nil {
+		return err
+	}
+	t, err := tenantFrom(stream.Context())
+	if err != nil {
+		return storeError(err, "")
+	}
+	seq := req.GetAfterSequence()
+	if seq == 0 && !req.GetHasAfterSequence() {
+		seq = t.feed.head()
+	}
+	filter := listOptions{
+		AuthorID: req.GetAuthorId(),
+		Tags:     normalizeTags(req.GetTags()),
+	}
+
+	for {
+		events, wait, err := t.feed.since(seq)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			seq = e.Seq
+			if !filter.matches(e.Blog) {
+				continue
+			}
+			blog := dataToBlogPb(e.Blog)
+			if !e.Blog.published() {
+				// watchers only learn that a blog they may be showing
+				// went away, nothing about drafts
+				if e.Kind != blogpb.WatchBlogsResponse_UNPUBLISHED {
+					continue
+				}
+				blog = &blogpb.Blog{Id: blog.GetId(), Status: blog.GetStatus()}
+			}
+			err := stream.Send(&blogpb.WatchBlogsResponse{
+				Sequence:  e.Seq,
+				Kind:      e.Kind,
+				Blog:      blog,
+				ChangedAt: timestamppb.New(e.At),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-wait:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// watchedStore publishes every write that goes through the BlogStore it
+// wraps to a changeFeed, once the write is done
+type watchedStore struct {
+	BlogStore
+	feed *changeFeed
+}
+
+func newWatchedStore(store BlogStore, feed *changeFeed) *watchedStore {
+	return &watchedStore{BlogStore: store, feed: feed}
+}
+
+func (s *watchedStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	created, err := s.BlogStore.Create(ctx, item)
+	if err == nil {
+		s.feed.publish(blogpb.WatchBlogsResponse_CREATED, created)
+	}
+	return created, err
+}
+
+func (s *watchedStore) CreateOnce(ctx context.Context, item *blogItem, key *idempotencyItem) (*blogItem, *idempotencyItem, error) {
+	created, prior, err := s.BlogStore.CreateOnce(ctx, item, key)
+	if created != nil {
+		s.feed.publish(blogpb.WatchBlogsResponse_CREATED, created)
+	}
+	return created, prior, err
+}
+
+func (s *watchedStore) Update(ctx context.Context, item *blogItem, fields []string) (*blogItem, error) {
+	updated, err := s.BlogStore.Update(ctx, item, fields)
+	if err == nil {
+		s.feed.publish(blogpb.WatchBlogsResponse_UPDATED, updated)
+	}
+	return updated, err
+}
+
+func (s *watchedStore) Delete(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	trashed, err := s.BlogStore.Delete(ctx, id)
+	if err == nil {
+		s.feed.publish(blogpb.WatchBlogsResponse_DELETED, trashed)
+	}
+	return trashed, err
+}
+
+func (s *watchedStore) Restore(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	restored, err := s.BlogStore.Restore(ctx, id)
+	if err == nil {
+		s.feed.publish(blogpb.WatchBlogsResponse_RESTORED, restored)
+	}
+	return restored, err
+}
+
+func (s *watchedStore) SetStatus(ctx context.Context, id primitive.ObjectID, from, to string, publishAt *time.Time) (*blogItem, error) {
+	changed, err := s.BlogStore.SetStatus(ctx, id, from, to, publishAt)
+	if err == nil {
+		kind := blogpb.WatchBlogsResponse_UNPUBLISHED
+		if changed.published() {
+			kind = blogpb.WatchBlogsResponse_PUBLISHED
+		}
+		s.feed.publish(kind, changed)
+	}
+	return changed, err
+}
+
+func (s *watchedStore) PublishDue(ctx context.Context, now time.Time) ([]*blogItem, error) {
+	published, err := s.BlogStore.PublishDue(ctx, now)
+	for _, item := range published {
+		s.feed.publish(blogpb.WatchBlogsResponse_PUBLISHED, item)
+	}
+	return published, err
+}
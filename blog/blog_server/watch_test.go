@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"grpc-go-course/blog/blogpb"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestChangeFeedPublish(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		blogs  []*blogItem // published in this order
+		events []int64     // the versions that come out
+	}{
+		{"in order", []*blogItem{{ID: a, Version: 1}, {ID: a, Version: 2}}, []int64{1, 2}},
+		{"older update last", []*blogItem{{ID: a, Version: 3}, {ID: a, Version: 2}}, []int64{3}},
+		{"same version", []*blogItem{{ID: a, Version: 2}, {ID: a, Version: 2}}, []int64{2, 2}},
+		{"other blogs", []*blogItem{{ID: a, Version: 3}, {ID: b, Version: 1}}, []int64{3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newChangeFeed(10)
+			for _, item := range tt.blogs {
+				f.publish(blogpb.WatchBlogsResponse_UPDATED, item)
+			}
+			events, _, err := f.since(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, e := range events {
+				got = append(got, e.Blog.Version)
+			}
+			if !reflect.DeepEqual(got, tt.events) {
+				t.Errorf("published versions %v, want %v", got, tt.events)
+			}
+		})
+	}
+}
+
+func TestChangeFeedForgetsOldEvents(t *testing.T) {
+	f := newChangeFeed(2)
+	for i := 0; i < 5; i++ {
+		f.publish(blogpb.WatchBlogsResponse_CREATED, &blogItem{ID: primitive.NewObjectID(), Version: 1})
+	}
+	if len(f.newest) != 2 {
+		t.Errorf("the feed remembers %v blogs, want the 2 it buffers", len(f.newest))
+	}
+}
+
+// watchStream collects what WatchBlogs sends
+type watchStream struct {
+	blogpb.BlogService_WatchBlogsServer
+	ctx  context.Context
+	sent []*blogpb.WatchBlogsResponse
+}
+
+func (s *watchStream) Context() context.Context { return s.ctx }
+
+func (s *watchStream) Send(res *blogpb.WatchBlogsResponse) error {
+	s.sent = append(s.sent, res)
+	return nil
+}
+
+func TestWatchBlogsStart(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *blogpb.WatchBlogsRequest
+		sent int // of the one event published before the watch
+	}{
+		{"new events only", &blogpb.WatchBlogsRequest{}, 0},
+		{"after sequence 0", &blogpb.WatchBlogsRequest{HasAfterSequence: true}, 1},
+		{"after sequence 1", &blogpb.WatchBlogsRequest{AfterSequence: 1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testTenants(t, "a")
+			ctx := testContext(t, ts, "a")
+			if _, err := (tenantStore{}).Create(ctx, &blogItem{Title: "t", Status: statusPublished}); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			stream := &watchStream{ctx: ctx}
+			if err := (&server{}).WatchBlogs(tt.req, stream); err != nil {
+				t.Fatal(err)
+			}
+			if len(stream.sent) != tt.sent {
+				t.Errorf("sent %v events, want %v", len(stream.sent), tt.sent)
+			}
+		})
+	}
+}